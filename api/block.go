@@ -0,0 +1,87 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"net/http"
+)
+
+// BlockUser blocks another user for the current user, removing any follows between them
+func BlockUser(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		blockedID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if blockedID == user.UserID {
+			http.Error(w, "Cannot block yourself", http.StatusBadRequest)
+			return
+		}
+
+		block := types.BlockedUser{BlockerID: user.UserID, BlockedUserID: blockedID}
+		if err := block.Create(r.Context(), db.Db); err != nil {
+			if storage.IsForeignKeyViolation(err) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to block user", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnblockUser removes the current user's block on another user
+func UnblockUser(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		blockedID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		block := types.BlockedUser{BlockerID: user.UserID, BlockedUserID: blockedID}
+		if err := block.Delete(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to unblock user", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListBlocks returns the users the current user has blocked
+func ListBlocks(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		blocked, err := types.ListBlockedUsers(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load blocked users", http.StatusInternalServerError)
+			return
+		}
+		if blocked == nil {
+			blocked = []types.BlockedUser{}
+		}
+
+		writeJSON(w, http.StatusOK, blocked)
+	}
+}
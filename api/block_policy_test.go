@@ -0,0 +1,481 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// These tests exercise the block policy against a real database. Set TEST_DATABASE_URL to a Postgres
+// connection string to run them; each test gets its own schema, which is dropped afterwards.
+
+// testDB applies init.sql to a fresh schema and returns a connection that uses it
+func testDB(t *testing.T) *storage.DB {
+	t.Helper()
+	conn := os.Getenv("TEST_DATABASE_URL")
+	if conn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sqlx.Connect("postgres", conn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	schema := "test_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		admin.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+
+	// Unknown connection parameters are sent to the server as settings
+	if strings.Contains(conn, "://") {
+		sep := "?"
+		if strings.Contains(conn, "?") {
+			sep = "&"
+		}
+		conn += sep + "search_path=" + schema
+	} else {
+		conn += " search_path=" + schema
+	}
+	db, err := storage.NewDB(conn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ddl, err := os.ReadFile("../init.sql")
+	if err != nil {
+		t.Fatalf("read init.sql: %v", err)
+	}
+	if _, err := db.Db.Exec(string(ddl)); err != nil {
+		t.Fatalf("apply init.sql: %v", err)
+	}
+	return db
+}
+
+// createTestUser inserts a user with every column the users model scans filled in
+func createTestUser(t *testing.T, db *storage.DB, username string) *types.User {
+	t.Helper()
+	user := &types.User{UserID: uuid.New().String(), Username: username}
+	query := `INSERT INTO users (user_id, username, user_password, email, first_name, last_name, user_bio, birthday,
+				profile_picture_url, latitude, longitude, session_token)
+			  VALUES ($1, $2, 'password', $2 || '@example.com', '', '', '', '', '', 40.7, -74.0, '')`
+	if _, err := db.Db.Exec(query, user.UserID, user.Username); err != nil {
+		t.Fatalf("create user %s: %v", username, err)
+	}
+	return user
+}
+
+// serve runs handler for one request made by username, routed through pattern so URL parameters resolve
+func serve(t *testing.T, handler http.HandlerFunc, method, pattern, path string, user *types.User, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Method(method, pattern, handler)
+	req := httptest.NewRequest(method, path, &payload)
+	req = req.WithContext(context.WithValue(req.Context(), ContextKeyUser, user.Username))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode reads a JSON response body into v
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+}
+
+// blockFixture is a blocker and a blocked user who were connected in every way before the block
+type blockFixture struct {
+	db             *storage.DB
+	bus            *events.Bus
+	hub            *realtime.Hub
+	blocker        *types.User
+	blocked        *types.User
+	other          *types.User
+	post           types.Post
+	conversationID string
+	groupID        string
+}
+
+func newBlockFixture(t *testing.T) *blockFixture {
+	t.Helper()
+	db := testDB(t)
+	f := &blockFixture{
+		db:      db,
+		bus:     events.NewBus(100),
+		hub:     realtime.NewHub(db, ""),
+		blocker: createTestUser(t, db, "blocker_user"),
+		blocked: createTestUser(t, db, "blocked_user"),
+		other:   createTestUser(t, db, "other_user"),
+	}
+
+	post := map[string]interface{}{"content": "hello #blockpolicy", "latitude": 40.7, "longitude": -74.0, "location_name": "Test"}
+	rec := serve(t, CreatePost(db, f.bus), http.MethodPost, "/posts", "/posts", f.blocker, post)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create post: %d %s", rec.Code, rec.Body)
+	}
+	decode(t, rec, &f.post)
+
+	if rec := serve(t, Follow(db, f.bus), http.MethodPut, "/users/{userID}/follow", "/users/"+f.blocker.UserID+"/follow", f.blocked, nil); rec.Code != http.StatusOK {
+		t.Fatalf("follow: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, Follow(db, f.bus), http.MethodPut, "/users/{userID}/follow", "/users/"+f.blocked.UserID+"/follow", f.blocker, nil); rec.Code != http.StatusOK {
+		t.Fatalf("follow back: %d %s", rec.Code, rec.Body)
+	}
+	if rec := serve(t, AddCloseFriend(db), http.MethodPut, "/close-friends/{userID}", "/close-friends/"+f.blocked.UserID, f.blocker, nil); rec.Code >= 300 {
+		t.Fatalf("add close friend: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := db.Db.Exec(`INSERT INTO feed_items (user_id, post_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, f.blocked.UserID, f.post.PostID); err != nil {
+		t.Fatalf("add feed item: %v", err)
+	}
+
+	var conv types.Membership
+	rec = serve(t, OpenConversation(db), http.MethodPost, "/conversations", "/conversations", f.blocked, map[string]string{"user_id": f.blocker.UserID})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("open conversation: %d %s", rec.Code, rec.Body)
+	}
+	decode(t, rec, &conv)
+	f.conversationID = conv.ConversationID
+
+	group := map[string]interface{}{"name": "Block policy", "member_ids": []string{f.blocker.UserID, f.other.UserID}}
+	rec = serve(t, CreateGroup(db, f.hub), http.MethodPost, "/conversations/groups", "/conversations/groups", f.blocked, group)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create group: %d %s", rec.Code, rec.Body)
+	}
+	var membership types.Membership
+	decode(t, rec, &membership)
+	f.groupID = membership.ConversationID
+
+	rec = serve(t, BlockUser(db), http.MethodPut, "/users/{userID}/block", "/users/"+f.blocked.UserID+"/block", f.blocker, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("block: %d %s", rec.Code, rec.Body)
+	}
+	return f
+}
+
+// count runs a COUNT query and returns its result
+func (f *blockFixture) count(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := f.db.Db.Get(&n, query, args...); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	return n
+}
+
+func TestBlockRemovesFollowsAndCloseFriends(t *testing.T) {
+	f := newBlockFixture(t)
+
+	follows := f.count(t, `SELECT COUNT(*) FROM followings WHERE (follower_id = $1 AND following_id = $2) OR (follower_id = $2 AND following_id = $1)`,
+		f.blocker.UserID, f.blocked.UserID)
+	if follows != 0 {
+		t.Errorf("follows remaining after block = %d, want 0", follows)
+	}
+	friends := f.count(t, `SELECT COUNT(*) FROM close_friends WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)`,
+		f.blocker.UserID, f.blocked.UserID)
+	if friends != 0 {
+		t.Errorf("close friend entries remaining after block = %d, want 0", friends)
+	}
+}
+
+func TestBlockHidesProfileBothWays(t *testing.T) {
+	f := newBlockFixture(t)
+
+	for _, c := range []struct {
+		name         string
+		viewer, user *types.User
+	}{
+		{"blocked views blocker", f.blocked, f.blocker},
+		{"blocker views blocked", f.blocker, f.blocked},
+	} {
+		rec := serve(t, GetProfile(f.db), http.MethodGet, "/users/{userID}", "/users/"+c.user.UserID, c.viewer, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", c.name, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	rec := serve(t, GetProfile(f.db), http.MethodGet, "/users/{userID}", "/users/"+f.blocker.UserID, f.other, nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("unrelated viewer: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestBlockHidesPostsFromReadPaths(t *testing.T) {
+	f := newBlockFixture(t)
+
+	paths := []struct {
+		name    string
+		handler http.HandlerFunc
+		pattern string
+		path    string
+	}{
+		{"feed", Feed(f.db), "/feed", "/feed"},
+		{"explore", Explore(f.db, types.ExploreWeightsFromEnv()), "/explore", "/explore"},
+		{"user posts", ListUserPosts(f.db), "/users/{userID}/posts", "/users/" + f.blocker.UserID + "/posts"},
+		{"tag posts", ListTagPosts(f.db), "/tags/{tag}/posts", "/tags/blockpolicy/posts"},
+	}
+	for _, p := range paths {
+		rec := serve(t, p.handler, http.MethodGet, p.pattern, p.path, f.blocked, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status = %d, want %d", p.name, rec.Code, http.StatusOK)
+			continue
+		}
+		var posts []types.Post
+		decode(t, rec, &posts)
+		for _, post := range posts {
+			if post.PostID == f.post.PostID {
+				t.Errorf("%s: blocked user can see the blocker's post", p.name)
+			}
+		}
+	}
+
+	// The same post is still visible to everyone else
+	rec := serve(t, ListTagPosts(f.db), http.MethodGet, "/tags/{tag}/posts", "/tags/blockpolicy/posts", f.other, nil)
+	var posts []types.Post
+	decode(t, rec, &posts)
+	if len(posts) != 1 || posts[0].PostID != f.post.PostID {
+		t.Errorf("unrelated viewer: tag posts = %v, want the blocker's post", posts)
+	}
+}
+
+func TestBlockPreventsInteraction(t *testing.T) {
+	f := newBlockFixture(t)
+	postPath := "/posts/" + f.post.PostID
+
+	actions := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		pattern string
+		path    string
+		body    interface{}
+	}{
+		{"follow", Follow(f.db, f.bus), http.MethodPut, "/users/{userID}/follow", "/users/" + f.blocker.UserID + "/follow", nil},
+		{"close friend", AddCloseFriend(f.db), http.MethodPut, "/close-friends/{userID}", "/close-friends/" + f.blocker.UserID, nil},
+		{"comment", CreateComment(f.db, f.bus), http.MethodPost, "/posts/{postID}/comments", postPath + "/comments", map[string]string{"content": "hi"}},
+		{"like", LikePost(f.db, f.bus), http.MethodPut, "/posts/{postID}/like", postPath + "/like", nil},
+		{"reaction", ReactToPost(f.db, f.bus), http.MethodPut, "/posts/{postID}/reaction", postPath + "/reaction", map[string]string{"reaction": types.ReactionHeart}},
+		{"open conversation", OpenConversation(f.db), http.MethodPost, "/conversations", "/conversations", map[string]string{"user_id": f.blocker.UserID}},
+		{"send message", SendMessage(f.db, f.hub, f.bus), http.MethodPost, "/conversations/{conversationID}/messages",
+			"/conversations/" + f.conversationID + "/messages", map[string]string{"content": "hi"}},
+	}
+	for _, a := range actions {
+		rec := serve(t, a.handler, a.method, a.pattern, a.path, f.blocked, a.body)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", a.name, rec.Code, http.StatusNotFound)
+		}
+	}
+
+	if n := f.count(t, `SELECT COUNT(*) FROM likes WHERE post_id = $1`, f.post.PostID); n != 0 {
+		t.Errorf("likes on the blocker's post = %d, want 0", n)
+	}
+	if n := f.count(t, `SELECT COUNT(*) FROM comments WHERE post_id = $1`, f.post.PostID); n != 0 {
+		t.Errorf("comments on the blocker's post = %d, want 0", n)
+	}
+	if n := f.count(t, `SELECT COUNT(*) FROM messages WHERE conversation_id = $1`, f.conversationID); n != 0 {
+		t.Errorf("messages in the direct conversation = %d, want 0", n)
+	}
+}
+
+func TestBlockPreventsMentions(t *testing.T) {
+	f := newBlockFixture(t)
+
+	post := map[string]interface{}{"content": "hey @blocker_user and @other_user", "latitude": 40.7, "longitude": -74.0, "location_name": "Test"}
+	rec := serve(t, CreatePost(f.db, f.bus), http.MethodPost, "/posts", "/posts", f.blocked, post)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create post: %d %s", rec.Code, rec.Body)
+	}
+	var created types.Post
+	decode(t, rec, &created)
+
+	var mentioned []string
+	if err := f.db.Db.Select(&mentioned, `SELECT user_id FROM post_mentions WHERE post_id = $1`, created.PostID); err != nil {
+		t.Fatalf("list mentions: %v", err)
+	}
+	if len(mentioned) != 1 || mentioned[0] != f.other.UserID {
+		t.Errorf("mentioned = %v, want only %s", mentioned, f.other.UserID)
+	}
+}
+
+func TestBlockStopsGroupMessageDelivery(t *testing.T) {
+	f := newBlockFixture(t)
+
+	rec := serve(t, SendMessage(f.db, f.hub, f.bus), http.MethodPost, "/conversations/{conversationID}/messages",
+		"/conversations/"+f.groupID+"/messages", f.blocked, map[string]string{"content": "hi all"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("send group message: %d %s", rec.Code, rec.Body)
+	}
+	var message types.Message
+	decode(t, rec, &message)
+
+	receipts := f.count(t, `SELECT COUNT(*) FROM message_receipts WHERE message_id = $1 AND user_id = $2`, message.MessageID, f.blocker.UserID)
+	if receipts != 0 {
+		t.Errorf("receipts for the blocker = %d, want 0", receipts)
+	}
+	receipts = f.count(t, `SELECT COUNT(*) FROM message_receipts WHERE message_id = $1 AND user_id = $2`, message.MessageID, f.other.UserID)
+	if receipts != 1 {
+		t.Errorf("receipts for the other member = %d, want 1", receipts)
+	}
+}
+
+func TestCanInteractWithSelf(t *testing.T) {
+	f := newBlockFixture(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	allowed, err := types.CanInteract(ctx, f.db.Db, f.blocked.UserID, f.blocked.UserID)
+	if err != nil || !allowed {
+		t.Errorf("CanInteract(self) = %v, %v; want true, nil", allowed, err)
+	}
+	allowed, err = types.CanInteract(ctx, f.db.Db, f.blocked.UserID, f.blocker.UserID)
+	if err != nil || allowed {
+		t.Errorf("CanInteract(blocked, blocker) = %v, %v; want false, nil", allowed, err)
+	}
+}
+
+// otherPost creates a post by the unrelated user that the blocked user comments on and likes after the block
+func (f *blockFixture) otherPost(t *testing.T) (types.Post, types.Comment) {
+	t.Helper()
+	var post types.Post
+	body := map[string]interface{}{"content": "open to all", "latitude": 40.7, "longitude": -74.0, "location_name": "Test"}
+	rec := serve(t, CreatePost(f.db, f.bus), http.MethodPost, "/posts", "/posts", f.other, body)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create post: %d %s", rec.Code, rec.Body)
+	}
+	decode(t, rec, &post)
+
+	var comment types.Comment
+	rec = serve(t, CreateComment(f.db, f.bus), http.MethodPost, "/posts/{postID}/comments", "/posts/"+post.PostID+"/comments",
+		f.blocked, map[string]string{"content": "first"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create comment: %d %s", rec.Code, rec.Body)
+	}
+	decode(t, rec, &comment)
+
+	rec = serve(t, EditComment(f.db), http.MethodPatch, "/comments/{commentID}", "/comments/"+comment.CommentID,
+		f.blocked, map[string]string{"content": "second"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("edit comment: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(t, LikePost(f.db, f.bus), http.MethodPut, "/posts/{postID}/like", "/posts/"+post.PostID+"/like", f.blocked, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("like post: %d %s", rec.Code, rec.Body)
+	}
+	return post, comment
+}
+
+func TestBlockHidesCommentsAndTheirHistory(t *testing.T) {
+	f := newBlockFixture(t)
+	post, comment := f.otherPost(t)
+
+	rec := serve(t, ListPostComments(f.db), http.MethodGet, "/posts/{postID}/comments", "/posts/"+post.PostID+"/comments", f.blocker, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list comments: %d %s", rec.Code, rec.Body)
+	}
+	var page types.CommentPage
+	decode(t, rec, &page)
+	for _, c := range page.Comments {
+		if c.CommentID == comment.CommentID {
+			t.Errorf("blocker can see the blocked user's comment")
+		}
+	}
+
+	rec = serve(t, ListCommentHistory(f.db), http.MethodGet, "/comments/{commentID}/history", "/comments/"+comment.CommentID+"/history", f.blocker, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("comment history: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	rec = serve(t, LikeComment(f.db, f.bus), http.MethodPut, "/comments/{commentID}/like", "/comments/"+comment.CommentID+"/like", f.blocker, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("comment like: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// Everyone else still sees the comment and its history
+	rec = serve(t, ListCommentHistory(f.db), http.MethodGet, "/comments/{commentID}/history", "/comments/"+comment.CommentID+"/history", f.other, nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("unrelated viewer comment history: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestBlockHidesLikes(t *testing.T) {
+	f := newBlockFixture(t)
+	post, _ := f.otherPost(t)
+	ctx := context.Background()
+
+	likes, err := types.ListLikesForPost(ctx, f.db.Db, post.PostID, f.blocker.UserID)
+	if err != nil {
+		t.Fatalf("list likes: %v", err)
+	}
+	for _, l := range likes {
+		if l.UserID == f.blocked.UserID {
+			t.Errorf("blocker can see the blocked user's like")
+		}
+	}
+
+	likes, err = types.ListLikesByUser(ctx, f.db.Db, f.blocked.UserID, f.blocker.UserID)
+	if err != nil {
+		t.Fatalf("list likes by user: %v", err)
+	}
+	if len(likes) != 0 {
+		t.Errorf("blocker sees %d of the blocked user's likes, want 0", len(likes))
+	}
+
+	likes, err = types.ListLikesForPost(ctx, f.db.Db, post.PostID, f.other.UserID)
+	if err != nil {
+		t.Fatalf("list likes: %v", err)
+	}
+	if len(likes) != 1 {
+		t.Errorf("unrelated viewer sees %d likes, want 1", len(likes))
+	}
+}
+
+func TestBlockSuppressesNotifications(t *testing.T) {
+	f := newBlockFixture(t)
+
+	notice := types.Notice{
+		UserID:   f.blocker.UserID,
+		ActorID:  f.blocked.UserID,
+		Type:     "post_like",
+		Category: types.CategoryLikes,
+		TargetID: f.post.PostID,
+		Action:   "liked your post",
+		GroupKey: "post_like:" + f.post.PostID,
+	}
+	delivery, err := types.Notify(context.Background(), f.db.Db, notice)
+	if err != nil {
+		t.Fatalf("notify: %v", err)
+	}
+	if delivery != nil {
+		t.Errorf("delivery across a block = %+v, want nil", delivery)
+	}
+	if n := f.count(t, `SELECT COUNT(*) FROM notifications WHERE user_id = $1`, f.blocker.UserID); n != 0 {
+		t.Errorf("notifications for the blocker = %d, want 0", n)
+	}
+}
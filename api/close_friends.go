@@ -0,0 +1,91 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"net/http"
+	"time"
+)
+
+// ListCloseFriends returns the current user's close friends list
+func ListCloseFriends(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		friends, err := types.ListCloseFriends(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load close friends", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, friends)
+	}
+}
+
+// AddCloseFriend adds a user to the current user's close friends list
+func AddCloseFriend(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		friendID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if friendID == user.UserID {
+			http.Error(w, "Cannot add yourself as a close friend", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, user.UserID, friendID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		friend := types.CloseFriend{UserID: user.UserID, FriendID: friendID, CreatedAt: time.Now()}
+		if err := friend.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to add close friend", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RemoveCloseFriend removes a user from the current user's close friends list
+func RemoveCloseFriend(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		friendID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		friend := types.CloseFriend{UserID: user.UserID, FriendID: friendID}
+		if err := friend.Delete(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to remove close friend", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,502 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// maxCommentLength is the longest comment content accepted
+const maxCommentLength = 2200
+
+// CreateComment comments on a post, or replies to a top-level comment when parent_comment_id is set
+func CreateComment(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var comment types.Comment
+		if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		comment.Content = strings.TrimSpace(comment.Content)
+		if comment.Content == "" || len(comment.Content) > maxCommentLength {
+			http.Error(w, "Invalid comment content", http.StatusBadRequest)
+			return
+		}
+
+		var post types.Post
+		err = post.ReadVisible(r.Context(), db.Db, postID, author.UserID)
+		if err == nil && post.Target() != post.PostID {
+			// Plain reposts carry the original's comments
+			err = post.ReadVisible(r.Context(), db.Db, post.Target(), author.UserID)
+		}
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, author.UserID, post.UserID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		if post.CommentsDisabled {
+			http.Error(w, "Comments are turned off for this post", http.StatusForbidden)
+			return
+		}
+
+		var parentAuthorID string
+		if comment.ParentCommentID != nil {
+			parentID, err := uuid.Parse(*comment.ParentCommentID)
+			if err != nil {
+				http.Error(w, "Invalid parent comment ID", http.StatusBadRequest)
+				return
+			}
+
+			var parent types.Comment
+			if err := parent.Read(r.Context(), db.Db, parentID); err != nil || parent.PostID != post.PostID || parent.DeletedAt != nil {
+				http.Error(w, "Parent comment not found", http.StatusNotFound)
+				return
+			}
+			allowed, err := types.CanInteract(r.Context(), db.Db, author.UserID, parent.UserID)
+			if err != nil {
+				http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Parent comment not found", http.StatusNotFound)
+				return
+			}
+
+			parentAuthorID = parent.UserID
+
+			// Threads are one level deep; replying to a reply joins the top-level thread
+			if parent.ParentCommentID != nil {
+				comment.ParentCommentID = parent.ParentCommentID
+			} else {
+				id := parent.CommentID
+				comment.ParentCommentID = &id
+			}
+		}
+
+		comment.CommentID = uuid.New().String()
+		comment.UserID = author.UserID
+		comment.PostID = post.PostID
+		comment.CreatedAt = time.Now()
+		comment.EditedAt = nil
+		comment.DeletedAt = nil
+		comment.ReplyCount = 0
+
+		if err := comment.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+			return
+		}
+
+		// A reply to the post author's own comment tells them once, as a reply
+		if parentAuthorID != "" {
+			bus.Emit(events.Event{Type: events.CommentReplied, ActorID: author.UserID, UserID: parentAuthorID, TargetID: comment.CommentID, Text: comment.Content})
+		}
+		if parentAuthorID != post.UserID {
+			bus.Emit(events.Event{Type: events.CommentCreated, ActorID: author.UserID, UserID: post.UserID, TargetID: comment.CommentID, Text: comment.Content})
+		}
+
+		writeJSON(w, http.StatusCreated, comment)
+	}
+}
+
+// ListPostComments returns a page of top-level comments on a post with their reply counts.
+// sort is "newest" (the default) or "top"; the pinned comment leads the first page.
+func ListPostComments(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		sort := r.URL.Query().Get("sort")
+		if sort == "" {
+			sort = types.CommentSortNewest
+		}
+		if sort != types.CommentSortNewest && sort != types.CommentSortTop {
+			http.Error(w, "Invalid sort", http.StatusBadRequest)
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Plain reposts show the original's comments
+		var post types.Post
+		if err := post.ReadVisible(r.Context(), db.Db, postID, viewer.UserID); err == nil {
+			postID = post.Target()
+		}
+
+		page, err := types.ListComments(r.Context(), db.Db, postID, viewer.UserID, sort, cursor, queryInt(r, "limit", 20, 100))
+		if err != nil {
+			http.Error(w, "Failed to load comments", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// ListCommentReplies returns a page of replies to a comment, oldest first
+func ListCommentReplies(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		commentID, ok := urlID(r, "commentID")
+		if !ok {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := types.ListReplies(r.Context(), db.Db, commentID, viewer.UserID, cursor, queryInt(r, "limit", 20, 100))
+		if err != nil {
+			http.Error(w, "Failed to load replies", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// loadComment reads the comment in the route and its post, as long as the viewer may see the post and
+// neither the viewer nor the comment's author has blocked the other. It writes the error response and returns nils on failure.
+func loadComment(w http.ResponseWriter, r *http.Request, db *storage.DB, viewerID string) (*types.Comment, *types.Post) {
+	commentID, err := uuid.Parse(chi.URLParam(r, "commentID"))
+	if err != nil {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	var comment types.Comment
+	var post types.Post
+	err = comment.Read(r.Context(), db.Db, commentID)
+	if err == nil {
+		err = post.ReadVisible(r.Context(), db.Db, comment.PostID, viewerID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return nil, nil
+		}
+		http.Error(w, "Failed to load comment", http.StatusInternalServerError)
+		return nil, nil
+	}
+
+	allowed, err := types.CanInteract(r.Context(), db.Db, viewerID, comment.UserID)
+	if err != nil {
+		http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+		return nil, nil
+	}
+	if !allowed {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return nil, nil
+	}
+	return &comment, &post
+}
+
+// EditComment changes the content of one of the current user's comments within the edit window
+func EditComment(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		request.Content = strings.TrimSpace(request.Content)
+		if request.Content == "" || len(request.Content) > maxCommentLength {
+			http.Error(w, "Invalid comment content", http.StatusBadRequest)
+			return
+		}
+
+		comment, post := loadComment(w, r, db, user.UserID)
+		if comment == nil {
+			return
+		}
+		if comment.UserID != user.UserID {
+			http.Error(w, "Only the author can edit this comment", http.StatusForbidden)
+			return
+		}
+		if post.CommentsDisabled {
+			http.Error(w, "Comments are turned off for this post", http.StatusForbidden)
+			return
+		}
+		if !comment.Editable(time.Now()) {
+			http.Error(w, "Comment can no longer be edited", http.StatusForbidden)
+			return
+		}
+		if comment.Content == request.Content {
+			writeJSON(w, http.StatusOK, comment)
+			return
+		}
+
+		comment.Content = request.Content
+		if err := comment.Update(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to edit comment", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, comment)
+	}
+}
+
+// ListCommentHistory returns the prior versions of a comment, oldest first
+func ListCommentHistory(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		comment, _ := loadComment(w, r, db, viewer.UserID)
+		if comment == nil {
+			return
+		}
+
+		edits, err := types.ListCommentEdits(r.Context(), db.Db, comment.CommentID)
+		if err != nil {
+			http.Error(w, "Failed to load comment history", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, edits)
+	}
+}
+
+// DeleteComment deletes a comment, leaving a placeholder if it has replies.
+// Both the comment's author and the post's owner may delete it.
+func DeleteComment(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		comment, post := loadComment(w, r, db, user.UserID)
+		if comment == nil {
+			return
+		}
+		if comment.DeletedAt != nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if comment.UserID != user.UserID && post.UserID != user.UserID {
+			http.Error(w, "Only the author or the post owner can delete this comment", http.StatusForbidden)
+			return
+		}
+
+		if err := comment.Delete(r.Context(), db.Db, uuid.MustParse(comment.CommentID)); err != nil {
+			http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetPostComments turns comments on one of the current user's posts off or on
+func SetPostComments(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var request struct {
+			CommentsDisabled bool `json:"comments_disabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		updated, err := types.SetCommentsDisabled(r.Context(), db.Db, postID, user.UserID, request.CommentsDisabled)
+		if err != nil {
+			http.Error(w, "Failed to update comment settings", http.StatusInternalServerError)
+			return
+		}
+		if !updated {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, request)
+	}
+}
+
+// LikeComment likes a comment for the current user and notifies its author. Liking twice is a no-op.
+func LikeComment(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		comment, _ := loadComment(w, r, db, user.UserID)
+		if comment == nil {
+			return
+		}
+		if comment.DeletedAt != nil {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+
+		like := types.CommentLike{CommentID: comment.CommentID, UserID: user.UserID, CreatedAt: time.Now()}
+		liked, err := like.Create(r.Context(), db.Db)
+		if err != nil {
+			http.Error(w, "Failed to like comment", http.StatusInternalServerError)
+			return
+		}
+
+		if liked {
+			bus.Emit(events.Event{Type: events.CommentLiked, ActorID: user.UserID, UserID: comment.UserID, TargetID: comment.CommentID, Text: comment.Content})
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// UnlikeComment removes the current user's like from a comment. Unliking a comment that isn't liked is a no-op.
+func UnlikeComment(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		comment, _ := loadComment(w, r, db, user.UserID)
+		if comment == nil {
+			return
+		}
+
+		like := types.CommentLike{CommentID: comment.CommentID, UserID: user.UserID}
+		if _, err := like.Delete(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to unlike comment", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PinComment pins a top-level comment to the top of the current user's post, replacing any pinned comment
+func PinComment(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setPinnedComment(w, r, db, true)
+	}
+}
+
+// UnpinComment removes the pinned comment from the current user's post
+func UnpinComment(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setPinnedComment(w, r, db, false)
+	}
+}
+
+// setPinnedComment pins the comment in the route to its post, or clears the post's pin
+func setPinnedComment(w http.ResponseWriter, r *http.Request, db *storage.DB, pin bool) {
+	user, err := currentUser(r, db)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusUnauthorized)
+		return
+	}
+
+	comment, post := loadComment(w, r, db, user.UserID)
+	if comment == nil {
+		return
+	}
+	if post.UserID != user.UserID {
+		http.Error(w, "Only the post author can pin comments", http.StatusForbidden)
+		return
+	}
+
+	var commentID *string
+	if pin {
+		commentID = &comment.CommentID
+	} else if post.PinnedCommentID == nil || *post.PinnedCommentID != comment.CommentID {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	updated, err := types.PinComment(r.Context(), db.Db, post.PostID, user.UserID, commentID)
+	if err != nil {
+		http.Error(w, "Failed to pin comment", http.StatusInternalServerError)
+		return
+	}
+	if !updated {
+		http.Error(w, "Only live top-level comments can be pinned", http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
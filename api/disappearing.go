@@ -0,0 +1,85 @@
+package api
+
+import (
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseTimer parses a disappearing message timer such as "24h" or "7d". An empty or zero timer turns it off.
+func parseTimer(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, errors.New("invalid timer")
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < 0 {
+		return 0, errors.New("invalid timer")
+	}
+	return ttl, nil
+}
+
+// SetMessageTimer sets how long new messages in a conversation last before disappearing for everyone.
+// Any current member can change it; everyone in the conversation sees a system message about the change.
+func SetMessageTimer(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+		if !conv.Active() {
+			http.Error(w, "You are no longer in this conversation", http.StatusForbidden)
+			return
+		}
+
+		var request struct {
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		ttl, err := parseTimer(request.Duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := types.ValidateMessageTTL(ttl); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		updated, notices, err := types.SetMessageTTL(r.Context(), db.Db, conv.ConversationID, user.UserID, ttl)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Conversation not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to set message timer", http.StatusInternalServerError)
+			return
+		}
+		publishToGroup(r, db, hub, updated.ConversationID, user.UserID, notices)
+
+		writeJSON(w, http.StatusOK, updated)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimer(t *testing.T) {
+	tests := []struct {
+		in    string
+		want  time.Duration
+		valid bool
+	}{
+		{"", 0, true},
+		{"0", 0, true},
+		{" 0 ", 0, true},
+		{"0s", 0, true},
+		{"30s", 30 * time.Second, true},
+		{"5m", 5 * time.Minute, true},
+		{"24h", 24 * time.Hour, true},
+		{" 24h ", 24 * time.Hour, true},
+		{"1h30m", 90 * time.Minute, true},
+		{"0d", 0, true},
+		{"1d", 24 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"90d", 90 * 24 * time.Hour, true},
+		{"-1d", 0, false},
+		{"-1h", 0, false},
+		{"1.5d", 0, false},
+		{"d", 0, false},
+		{"7", 0, false},
+		{"7days", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseTimer(tt.in)
+		if (err == nil) != tt.valid || got != tt.want {
+			t.Errorf("parseTimer(%q) = %v, %v; want %v, valid %v", tt.in, got, err, tt.want, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Explore returns a ranked discovery feed for the current user
+func Explore(db *storage.DB, weights types.ExploreWeights) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		limit := queryInt(r, "limit", 20, 100)
+		debug := r.URL.Query().Get("debug") == "true"
+
+		candidates, err := types.ListExploreCandidates(r.Context(), db.Db, viewer.UserID, weights)
+		if err != nil {
+			http.Error(w, "Failed to load explore feed", http.StatusInternalServerError)
+			return
+		}
+
+		items := types.RankExplore(candidates, viewer, weights, limit, debug)
+		posts := make([]*types.Post, len(items))
+		for i := range items {
+			posts[i] = &items[i].Post
+		}
+		if err := hydratePosts(r, db, viewer.UserID, posts...); err != nil {
+			http.Error(w, "Failed to load explore feed", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, items)
+	}
+}
+
+// ViewPost marks a post as seen by the current user so it is excluded from explore
+func ViewPost(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var post types.Post
+		if err := post.ReadVisible(r.Context(), db.Db, postID, viewer.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+
+		view := types.PostView{UserID: viewer.UserID, PostID: post.PostID, ViewedAt: time.Now()}
+		if err := view.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to record view", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
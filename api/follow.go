@@ -0,0 +1,135 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Follow makes the current user follow another user. Following someone twice is a no-op.
+func Follow(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		follower, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if userID == follower.UserID {
+			http.Error(w, "Cannot follow yourself", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, follower.UserID, userID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		following := types.Following{FollowerID: follower.UserID, FollowingID: userID, CreatedAt: time.Now()}
+		followed, err := following.Create(r.Context(), db.Db)
+		if err != nil {
+			if storage.IsForeignKeyViolation(err) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to follow user", http.StatusInternalServerError)
+			return
+		}
+		if followed {
+			bus.Emit(events.Event{Type: events.UserFollowed, ActorID: follower.UserID, UserID: userID})
+		}
+
+		writeProfile(w, r, db, userID, follower.UserID)
+	}
+}
+
+// Unfollow makes the current user stop following another user
+func Unfollow(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		follower, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var following types.Following
+		if err := following.Delete(r.Context(), db.Db, follower.UserID, userID); err != nil {
+			http.Error(w, "Failed to unfollow user", http.StatusInternalServerError)
+			return
+		}
+
+		writeProfile(w, r, db, userID, follower.UserID)
+	}
+}
+
+// ListUserFollowers returns a page of a user's followers, most recent first
+func ListUserFollowers(db *storage.DB) http.HandlerFunc {
+	return listFollows(db, types.ListFollowers)
+}
+
+// ListUserFollowing returns a page of the users a user follows, most recent first
+func ListUserFollowing(db *storage.DB) http.HandlerFunc {
+	return listFollows(db, types.ListFollowings)
+}
+
+// listFollows serves a paginated follow list for the user in the route
+func listFollows(db *storage.DB, list func(ctx context.Context, db *sqlx.DB, userID, viewerID string, cursor types.Cursor, limit int) (types.FollowPage, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, viewer.UserID, userID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := list(r.Context(), db.Db, userID, viewer.UserID, cursor, queryInt(r, "limit", 20, 100))
+		if err != nil {
+			http.Error(w, "Failed to load follows", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// loadGroup reads the group conversation in the URL for a current member, writing an error unless they are
+// a member, and an admin when adminOnly is set
+func loadGroup(w http.ResponseWriter, r *http.Request, db *storage.DB, userID string, adminOnly bool) (*types.Membership, bool) {
+	conv, ok := loadConversation(w, r, db, userID)
+	if !ok {
+		return nil, false
+	}
+	if conv.Kind != types.ConversationGroup {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !conv.Active() {
+		http.Error(w, "You are no longer in this group", http.StatusForbidden)
+		return nil, false
+	}
+	if adminOnly && !conv.IsAdmin() {
+		http.Error(w, "Only group admins can do that", http.StatusForbidden)
+		return nil, false
+	}
+	return conv, true
+}
+
+// writeGroupError writes the response for a failed membership change
+func writeGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, types.ErrGroupFull):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, types.ErrLastAdmin):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, types.ErrNotInGroup):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Group not found", http.StatusNotFound)
+	case storage.IsForeignKeyViolation(err):
+		http.Error(w, "User not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Failed to update group", http.StatusInternalServerError)
+	}
+}
+
+// parseMemberIDs validates user IDs from a request and checks that actorID may interact with each of them
+func parseMemberIDs(w http.ResponseWriter, r *http.Request, db *storage.DB, actorID string, raw []string) ([]string, bool) {
+	ids := make([]string, 0, len(raw))
+	for _, s := range raw {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return nil, false
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, actorID, id.String())
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return nil, false
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return nil, false
+		}
+		ids = append(ids, id.String())
+	}
+	return ids, true
+}
+
+// publishToGroup delivers system messages to a conversation's current members and any extra users, such as someone just removed
+func publishToGroup(r *http.Request, db *storage.DB, hub *realtime.Hub, conversationID, actorID string, messages []types.Message, extra ...string) {
+	if len(messages) == 0 {
+		return
+	}
+	members, err := types.ListParticipantIDs(r.Context(), db.Db, conversationID, actorID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load group members")
+		return
+	}
+	publishMessages(r, hub, append(append(members, actorID), extra...), messages...)
+}
+
+// CreateGroup starts a group conversation with the current user as its admin
+func CreateGroup(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			Name      string   `json:"name"`
+			AvatarURL *string  `json:"avatar_url"`
+			MemberIDs []string `json:"member_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		name, err := types.ValidateGroupName(request.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(request.MemberIDs) == 0 {
+			http.Error(w, "A group needs at least one other member", http.StatusBadRequest)
+			return
+		}
+		memberIDs, ok := parseMemberIDs(w, r, db, user.UserID, request.MemberIDs)
+		if !ok {
+			return
+		}
+
+		conv, notices, err := types.CreateGroup(r.Context(), db.Db, user.UserID, name, request.AvatarURL, memberIDs)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, conv.ConversationID, user.UserID, notices)
+
+		writeJSON(w, http.StatusCreated, conv)
+	}
+}
+
+// UpdateGroup changes a group's name or avatar. Admins only.
+func UpdateGroup(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, true)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			Name      *string `json:"name"`
+			AvatarURL *string `json:"avatar_url"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if request.Name != nil {
+			name, err := types.ValidateGroupName(*request.Name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			request.Name = &name
+		}
+		if request.AvatarURL != nil {
+			trimmed := strings.TrimSpace(*request.AvatarURL)
+			request.AvatarURL = &trimmed
+		}
+
+		conv, notices, err := types.UpdateGroup(r.Context(), db.Db, group.ConversationID, user.UserID, request.Name, request.AvatarURL)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, conv.ConversationID, user.UserID, notices)
+
+		writeJSON(w, http.StatusOK, conv)
+	}
+}
+
+// ListGroupMembers returns a group's current members
+func ListGroupMembers(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, false)
+		if !ok {
+			return
+		}
+
+		members, err := types.ListGroupMembers(r.Context(), db.Db, group.ConversationID)
+		if err != nil {
+			http.Error(w, "Failed to load members", http.StatusInternalServerError)
+			return
+		}
+		if members == nil {
+			members = []types.GroupMember{}
+		}
+
+		writeJSON(w, http.StatusOK, members)
+	}
+}
+
+// AddGroupMembers invites users into a group, up to the member limit. Admins only.
+func AddGroupMembers(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, true)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			UserIDs []string `json:"user_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.UserIDs) == 0 {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		userIDs, ok := parseMemberIDs(w, r, db, user.UserID, request.UserIDs)
+		if !ok {
+			return
+		}
+
+		notices, err := types.AddGroupMembers(r.Context(), db.Db, group.ConversationID, user.UserID, userIDs)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, group.ConversationID, user.UserID, notices)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RemoveGroupMember removes a member from a group. Admins may remove anyone and members may remove themselves.
+func RemoveGroupMember(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		memberID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, memberID != user.UserID)
+		if !ok {
+			return
+		}
+
+		notices, err := types.RemoveGroupMember(r.Context(), db.Db, group.ConversationID, user.UserID, memberID)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, group.ConversationID, user.UserID, notices, memberID)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LeaveGroup removes the current user from a group. They keep the history from while they were a member.
+func LeaveGroup(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, false)
+		if !ok {
+			return
+		}
+
+		notices, err := types.RemoveGroupMember(r.Context(), db.Db, group.ConversationID, user.UserID, user.UserID)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, group.ConversationID, user.UserID, notices)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SetGroupAdmin returns a handler that makes a member an admin or a regular member. Admins only.
+func SetGroupAdmin(db *storage.DB, hub *realtime.Hub, role string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		memberID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		group, ok := loadGroup(w, r, db, user.UserID, true)
+		if !ok {
+			return
+		}
+
+		notices, err := types.SetGroupRole(r.Context(), db.Db, group.ConversationID, user.UserID, memberID, role)
+		if err != nil {
+			writeGroupError(w, err)
+			return
+		}
+		publishToGroup(r, db, hub, group.ConversationID, user.UserID, notices)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Error("Failed to write response")
+	}
+}
+
+// currentUser loads the user that owns the session on the request.
+func currentUser(r *http.Request, db *storage.DB) (*types.User, error) {
+	username, _ := GetUsernameFromRequest(r)
+	var user types.User
+	if err := user.ReadByUsername(r.Context(), db.Db, username); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// queryInt reads an integer query parameter, clamped to [1, max], falling back to def.
+func queryInt(r *http.Request, key string, def, max int) int {
+	v, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || v < 1 {
+		return def
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// urlID reads a UUID route parameter, reporting false when it is malformed.
+func urlID(r *http.Request, key string) (string, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, key))
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// hydratePosts loads the originals of reposts and the reaction counts and viewer reactions of every post.
+func hydratePosts(r *http.Request, db *storage.DB, viewerID string, posts ...*types.Post) error {
+	if err := types.AttachOriginals(r.Context(), db.Db, posts...); err != nil {
+		return err
+	}
+	return types.AttachReactions(r.Context(), db.Db, viewerID, posts...)
+}
+
+// postPtrs returns pointers to each post in posts, for hydration in place.
+func postPtrs(posts []types.Post) []*types.Post {
+	ptrs := make([]*types.Post, len(posts))
+	for i := range posts {
+		ptrs[i] = &posts[i]
+	}
+	return ptrs
+}
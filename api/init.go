@@ -1,7 +1,10 @@
 package api
 
 import (
+	"Engine/events"
+	"Engine/realtime"
 	"Engine/storage"
+	"Engine/types"
 	"log"
 	"net/http"
 	"os"
@@ -11,7 +14,7 @@ import (
 )
 
 
-func InitHandlers(router *chi.Mux, db *storage.DB) {
+func InitHandlers(router *chi.Mux, db *storage.DB, hub *realtime.Hub, bus *events.Bus) {
 	
 	// authenticated routes
 	apiRouter := chi.NewRouter()
@@ -19,6 +22,107 @@ func InitHandlers(router *chi.Mux, db *storage.DB) {
 	apiRouter.Use(RequestMiddleware)
 
 	router.Post("/register", RegisterAccount(db))
+
+	// posts
+	apiRouter.Post("/posts", CreatePost(db, bus))
+	apiRouter.Get("/users/{userID}/posts", ListUserPosts(db))
+	apiRouter.Get("/tags/{tag}/posts", ListTagPosts(db))
+	apiRouter.Get("/feed", Feed(db))
+	apiRouter.Get("/posts/drafts", ListDrafts(db))
+	apiRouter.Patch("/posts/drafts/{postID}", PatchDraft(db))
+	apiRouter.Post("/posts/drafts/{postID}/publish", PublishDraft(db, bus))
+	apiRouter.Delete("/posts/drafts/{postID}", DeleteDraft(db))
+	apiRouter.Get("/explore", Explore(db, types.ExploreWeightsFromEnv()))
+	apiRouter.Post("/posts/{postID}/view", ViewPost(db))
+	apiRouter.Post("/posts/{postID}/repost", Repost(db, bus))
+	apiRouter.Delete("/posts/{postID}/repost", UndoRepost(db))
+	apiRouter.Put("/posts/{postID}/like", LikePost(db, bus))
+	apiRouter.Delete("/posts/{postID}/like", UnlikePost(db))
+	apiRouter.Put("/posts/{postID}/reaction", ReactToPost(db, bus))
+	apiRouter.Delete("/posts/{postID}/reaction", UnlikePost(db))
+	apiRouter.Get("/reactions", ListReactionTypes())
+
+	// comments
+	apiRouter.Post("/posts/{postID}/comments", CreateComment(db, bus))
+	apiRouter.Get("/posts/{postID}/comments", ListPostComments(db))
+	apiRouter.Get("/comments/{commentID}/replies", ListCommentReplies(db))
+	apiRouter.Patch("/comments/{commentID}", EditComment(db))
+	apiRouter.Get("/comments/{commentID}/history", ListCommentHistory(db))
+	apiRouter.Delete("/comments/{commentID}", DeleteComment(db))
+	apiRouter.Put("/posts/{postID}/comment-settings", SetPostComments(db))
+	apiRouter.Put("/comments/{commentID}/like", LikeComment(db, bus))
+	apiRouter.Delete("/comments/{commentID}/like", UnlikeComment(db))
+	apiRouter.Put("/comments/{commentID}/pin", PinComment(db))
+	apiRouter.Delete("/comments/{commentID}/pin", UnpinComment(db))
+
+	// users
+	apiRouter.Get("/users/{userID}", GetProfile(db))
+	apiRouter.Put("/users/{userID}/follow", Follow(db, bus))
+	apiRouter.Delete("/users/{userID}/follow", Unfollow(db))
+	apiRouter.Get("/users/{userID}/followers", ListUserFollowers(db))
+	apiRouter.Get("/users/{userID}/following", ListUserFollowing(db))
+	apiRouter.Put("/users/{userID}/block", BlockUser(db))
+	apiRouter.Delete("/users/{userID}/block", UnblockUser(db))
+	apiRouter.Get("/blocks", ListBlocks(db))
+
+	// suggestions
+	apiRouter.Get("/suggestions", ListSuggestions(db))
+	apiRouter.Delete("/suggestions/{userID}", DismissSuggestion(db))
+
+	// close friends
+	apiRouter.Get("/close-friends", ListCloseFriends(db))
+	apiRouter.Put("/close-friends/{userID}", AddCloseFriend(db))
+	apiRouter.Delete("/close-friends/{userID}", RemoveCloseFriend(db))
+
+	// mutes
+	apiRouter.Post("/mutes", CreateMute(db))
+	apiRouter.Get("/mutes", ListMutes(db))
+	apiRouter.Delete("/mutes/{muteID}", DeleteMute(db))
+
+	// messaging
+	apiRouter.Get("/conversations", Inbox(db))
+	apiRouter.Get("/messages/search", SearchMessages(db))
+	apiRouter.Post("/conversations", OpenConversation(db))
+	apiRouter.Get("/conversations/{conversationID}/messages", ListConversationMessages(db, hub))
+	apiRouter.Post("/conversations/{conversationID}/messages", SendMessage(db, hub, bus))
+	apiRouter.Patch("/conversations/{conversationID}/messages/{messageID}", EditMessage(db, hub))
+	apiRouter.Delete("/conversations/{conversationID}/messages/{messageID}", UnsendMessage(db, hub))
+	apiRouter.Post("/conversations/{conversationID}/messages/{messageID}/hide", HideMessage(db, hub))
+	apiRouter.Post("/conversations/{conversationID}/read", MarkConversationRead(db, hub))
+	apiRouter.Put("/conversations/{conversationID}/timer", SetMessageTimer(db, hub))
+	apiRouter.Get("/conversations/{conversationID}/messages/{messageID}/receipts", ListMessageReceipts(db))
+	apiRouter.Get("/users/{userID}/presence", GetPresence(db))
+
+	// message requests
+	apiRouter.Get("/conversations/requests", ListMessageRequests(db))
+	apiRouter.Post("/conversations/{conversationID}/accept", AcceptMessageRequest(db))
+	apiRouter.Post("/conversations/{conversationID}/decline", DeclineMessageRequest(db))
+	apiRouter.Post("/conversations/{conversationID}/decline-and-block", DeclineAndBlockMessageRequest(db))
+
+	// media uploads
+	apiRouter.Post("/media", UploadMedia(db))
+	apiRouter.Get("/media/{mediaID}", ServeMedia(db, false))
+	apiRouter.Get("/media/{mediaID}/preview", ServeMedia(db, true))
+
+	// group conversations
+	apiRouter.Post("/conversations/groups", CreateGroup(db, hub))
+	apiRouter.Patch("/conversations/{conversationID}", UpdateGroup(db, hub))
+	apiRouter.Get("/conversations/{conversationID}/members", ListGroupMembers(db))
+	apiRouter.Post("/conversations/{conversationID}/members", AddGroupMembers(db, hub))
+	apiRouter.Delete("/conversations/{conversationID}/members/{userID}", RemoveGroupMember(db, hub))
+	apiRouter.Post("/conversations/{conversationID}/leave", LeaveGroup(db, hub))
+	apiRouter.Put("/conversations/{conversationID}/admins/{userID}", SetGroupAdmin(db, hub, types.RoleAdmin))
+	apiRouter.Delete("/conversations/{conversationID}/admins/{userID}", SetGroupAdmin(db, hub, types.RoleMember))
+
+	// settings
+	apiRouter.Get("/settings/privacy", GetPrivacySettings(db))
+	apiRouter.Put("/settings/privacy", UpdatePrivacySettings(db))
+	apiRouter.Get("/settings/notifications", GetNotificationSettings(db))
+	apiRouter.Put("/settings/notifications", UpdateNotificationSettings(db))
+
+	// realtime chat, authenticated by header or token query parameter
+	router.With(WebSocketTokenMiddleware, SessionMiddleware).Get("/chat/{userID}/start/ws", ChatSocket(db, hub))
+
 	router.Mount("/api/v1/", apiRouter)
 
 
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// loadLikeTarget resolves the post a like on postID applies to, checking the viewer may see it and isn't blocked by its author.
+// It writes the error response and returns nil on failure.
+func loadLikeTarget(w http.ResponseWriter, r *http.Request, db *storage.DB, viewerID string) *types.Post {
+	postID, ok := urlID(r, "postID")
+	if !ok {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return nil
+	}
+
+	var post types.Post
+	err := post.ReadVisible(r.Context(), db.Db, postID, viewerID)
+	if err == nil && post.Target() != post.PostID {
+		// Plain reposts carry the original's likes
+		err = post.ReadVisible(r.Context(), db.Db, post.Target(), viewerID)
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return nil
+		}
+		http.Error(w, "Failed to load post", http.StatusInternalServerError)
+		return nil
+	}
+
+	allowed, err := types.CanInteract(r.Context(), db.Db, viewerID, post.UserID)
+	if err != nil {
+		http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+		return nil
+	}
+	if !allowed {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return nil
+	}
+	return &post
+}
+
+// setReaction sets the current user's reaction on the post in the route, replacing any previous one
+func setReaction(w http.ResponseWriter, r *http.Request, db *storage.DB, bus *events.Bus, reaction string) {
+	viewer, err := currentUser(r, db)
+	if err != nil {
+		http.Error(w, "Failed to load user", http.StatusUnauthorized)
+		return
+	}
+
+	post := loadLikeTarget(w, r, db, viewer.UserID)
+	if post == nil {
+		return
+	}
+
+	like := types.Like{LikeID: uuid.New().String(), UserID: viewer.UserID, PostID: post.PostID, Reaction: reaction, CreatedAt: time.Now()}
+	first, err := like.Create(r.Context(), db.Db)
+	if err != nil {
+		http.Error(w, "Failed to react to post", http.StatusInternalServerError)
+		return
+	}
+	// Switching reactions isn't a new like, so only the first reaction notifies the author
+	if first {
+		bus.Emit(events.Event{Type: events.PostLiked, ActorID: viewer.UserID, UserID: post.UserID, TargetID: post.PostID})
+	}
+
+	state, err := types.ReadLikeState(r.Context(), db.Db, viewer.UserID, post.PostID)
+	if err != nil {
+		http.Error(w, "Failed to load reactions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, state)
+}
+
+// LikePost reacts to a post with a heart for the current user. Liking an already liked post is a no-op.
+func LikePost(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setReaction(w, r, db, bus, types.ReactionHeart)
+	}
+}
+
+// ReactToPost sets the current user's reaction on a post, replacing any previous reaction
+func ReactToPost(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Reaction string `json:"reaction"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if !types.ValidReaction(request.Reaction) {
+			http.Error(w, "Unsupported reaction", http.StatusBadRequest)
+			return
+		}
+
+		setReaction(w, r, db, bus, request.Reaction)
+	}
+}
+
+// ListReactionTypes returns the reactions users may choose from
+func ListReactionTypes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, types.ReactionTypes)
+	}
+}
+
+// UnlikePost removes the current user's reaction from a post. Unliking a post without a reaction is a no-op.
+func UnlikePost(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		post := loadLikeTarget(w, r, db, viewer.UserID)
+		if post == nil {
+			return
+		}
+
+		if _, err := types.DeleteLike(r.Context(), db.Db, viewer.UserID, post.PostID); err != nil {
+			http.Error(w, "Failed to remove reaction", http.StatusInternalServerError)
+			return
+		}
+
+		state, err := types.ReadLikeState(r.Context(), db.Db, viewer.UserID, post.PostID)
+		if err != nil {
+			http.Error(w, "Failed to load reactions", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	}
+}
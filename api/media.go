@@ -0,0 +1,111 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxUploadBytes is the largest request body accepted for an upload: the biggest per-kind limit plus form overhead
+func maxUploadBytes() int64 {
+	var max int64
+	for _, limit := range types.MediaMaxBytes {
+		if limit > max {
+			max = limit
+		}
+	}
+	return max + 1<<20
+}
+
+// UploadMedia stores a file sent as the "file" field of a multipart form and returns its media ID for attaching
+// to messages. The type is detected from the file contents and checked against the accepted types and size limits.
+func UploadMedia(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		limit := maxUploadBytes()
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, types.ErrMediaTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		data, err := io.ReadAll(io.LimitReader(file, limit))
+		if err != nil {
+			http.Error(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+
+		media := types.Media{MediaID: uuid.New().String(), UploaderID: user.UserID, Data: data, CreatedAt: time.Now()}
+		if err := media.Prepare(); err != nil {
+			switch {
+			case errors.Is(err, types.ErrMediaType):
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			case errors.Is(err, types.ErrMediaTooLarge):
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			default:
+				http.Error(w, "Failed to process file", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if err := media.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to store file", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, media)
+	}
+}
+
+// ServeMedia returns a handler that serves an uploaded file, or its preview when preview is set, to its uploader
+// and to anyone who can see a message it is attached to
+func ServeMedia(db *storage.DB, preview bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		mediaID, ok := urlID(r, "mediaID")
+		if !ok {
+			http.Error(w, "Invalid media ID", http.StatusBadRequest)
+			return
+		}
+
+		contentType, data, err := types.ReadMediaFile(r.Context(), db.Db, mediaID, user.UserID, preview)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Media not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load media", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,647 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxMessageLength is the longest message content accepted
+const maxMessageLength = 4000
+
+// OpenConversation returns the current user's direct conversation with another user, starting it if needed
+func OpenConversation(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			UserID string `json:"user_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		otherID, err := uuid.Parse(request.UserID)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if otherID.String() == user.UserID {
+			http.Error(w, "Cannot message yourself", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, user.UserID, otherID.String())
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		conv, err := types.OpenDirectConversation(r.Context(), db.Db, user.UserID, otherID.String())
+		if err != nil {
+			if storage.IsForeignKeyViolation(err) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to open conversation", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, conv)
+	}
+}
+
+// Inbox returns a page of the current user's conversations with their latest message and unread count
+func Inbox(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := types.ListInbox(r.Context(), db.Db, user.UserID, cursor, queryInt(r, "limit", 20, 50))
+		if err != nil {
+			http.Error(w, "Failed to load inbox", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// loadConversation reads the conversation in the URL as userID sees it, writing a 404 when they have never been a participant
+func loadConversation(w http.ResponseWriter, r *http.Request, db *storage.DB, userID string) (*types.Membership, bool) {
+	conversationID, ok := urlID(r, "conversationID")
+	if !ok {
+		http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	conv, err := types.ReadConversation(r.Context(), db.Db, conversationID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Conversation not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &conv, true
+}
+
+// ListConversationMessages returns a page of a conversation's history, newest first. Loading history counts as
+// delivery of everything in the conversation to the current user.
+func ListConversationMessages(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := types.ListConversationMessages(r.Context(), db.Db, conv.ConversationID, user.UserID, cursor, queryInt(r, "limit", 30, 100))
+		if err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+		if err := types.AttachMessageDetails(r.Context(), db.Db, user.UserID, types.MessagePtrs(page.Messages)...); err != nil {
+			http.Error(w, "Failed to load messages", http.StatusInternalServerError)
+			return
+		}
+
+		delivered, err := types.MarkConversationDelivered(r.Context(), db.Db, user.UserID, conv.ConversationID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to record message delivery")
+		} else if err := hub.PublishReceipts(r.Context(), user.UserID, types.ReceiptDelivered, delivered); err != nil {
+			logrus.WithError(err).Error("Failed to publish delivery receipts")
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// SendMessage sends a message to a conversation the current user participates in, delivers it in real time
+// to every connected device of its participants and notifies them unless it is a message request
+func SendMessage(db *storage.DB, hub *realtime.Hub, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+		if !conv.Active() {
+			http.Error(w, "You are no longer in this conversation", http.StatusForbidden)
+			return
+		}
+
+		var message types.Message
+		if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		message.Content = strings.TrimSpace(message.Content)
+		if len(message.Content) > maxMessageLength {
+			http.Error(w, "Message is too long", http.StatusBadRequest)
+			return
+		}
+		if message.ContentType == "" {
+			message.ContentType = types.MessageTypeText
+		}
+		if err := message.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Attachments must come from the sender's own uploads, never from arbitrary URLs
+		message.MediaURL = ""
+		if message.MediaID != nil {
+			media, err := types.ReadOwnMedia(r.Context(), db.Db, *message.MediaID, user.UserID)
+			if err != nil || media.Kind != message.ContentType {
+				http.Error(w, "Media not found", http.StatusBadRequest)
+				return
+			}
+			message.MediaURL = media.URL
+		}
+		if message.SharedPostID != nil {
+			visible, err := types.CanViewPost(r.Context(), db.Db, user.UserID, *message.SharedPostID)
+			if err != nil || !visible {
+				http.Error(w, "Post not found", http.StatusBadRequest)
+				return
+			}
+		}
+
+		recipients, err := types.ListParticipantIDs(r.Context(), db.Db, conv.ConversationID, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+			return
+		}
+
+		// A block ends a direct conversation; in groups, members who block each other can still both take part,
+		// but a message isn't pushed, receipted or notified across the block
+		message.ReceiverID = nil
+		if conv.Kind == types.ConversationGroup {
+			var reachable []string
+			for _, id := range recipients {
+				allowed, err := types.CanInteract(r.Context(), db.Db, user.UserID, id)
+				if err != nil {
+					http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+					return
+				}
+				if allowed {
+					reachable = append(reachable, id)
+				}
+			}
+			recipients = reachable
+		}
+		if conv.Kind == types.ConversationDirect {
+			if len(recipients) != 1 {
+				http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+				return
+			}
+			allowed, err := types.CanInteract(r.Context(), db.Db, user.UserID, recipients[0])
+			if err != nil {
+				http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Conversation not found", http.StatusNotFound)
+				return
+			}
+			message.ReceiverID = &recipients[0]
+		}
+
+		message.MessageID = uuid.New().String()
+		message.ConversationID = conv.ConversationID
+		message.SenderID = user.UserID
+		message.Timestamp = time.Now()
+		message.IsRead = false
+		if err := message.Send(r.Context(), db.Db); err != nil {
+			if errors.Is(err, types.ErrRequestNotAccepted) {
+				http.Error(w, "You can send more messages once your request is accepted", http.StatusForbidden)
+				return
+			}
+			http.Error(w, "Failed to send message", http.StatusInternalServerError)
+			return
+		}
+
+		publishMessages(r, hub, append(recipients, user.UserID), message)
+		notifyMessage(r, db, bus, &message, recipients)
+
+		if err := types.AttachMessageDetails(r.Context(), db.Db, user.UserID, &message); err != nil {
+			logrus.WithError(err).Error("Failed to load message attachments")
+		}
+
+		writeJSON(w, http.StatusCreated, message)
+	}
+}
+
+// notifyMessage tells recipients about a new message, except the recipient of a message request, which waits quietly
+// in their requests folder. Sending may have just opened the request, so the conversation's state is read afresh.
+func notifyMessage(r *http.Request, db *storage.DB, bus *events.Bus, message *types.Message, recipients []string) {
+	if message.ReceiverID != nil {
+		conv, err := types.ReadConversation(r.Context(), db.Db, message.ConversationID, message.SenderID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to load conversation")
+			return
+		}
+		if conv.RequestState != types.RequestAccepted {
+			return
+		}
+	}
+	for _, userID := range recipients {
+		bus.Emit(events.Event{Type: events.MessageSent, ActorID: message.SenderID, UserID: userID, TargetID: message.ConversationID, Text: message.Content})
+	}
+}
+
+// publishMessages delivers messages in real time to every connected device of recipients
+func publishMessages(r *http.Request, hub *realtime.Hub, recipients []string, messages ...types.Message) {
+	for _, message := range messages {
+		event := realtime.Event{Type: realtime.EventMessage, ConversationID: message.ConversationID, MessageID: message.MessageID}
+		if err := hub.Publish(r.Context(), recipients, event); err != nil {
+			logrus.WithError(err).Error("Failed to publish message")
+		}
+	}
+}
+
+// MarkConversationRead marks a conversation read by the current user up to and including a message, and tells
+// the senders unless the current user has turned read receipts off or hasn't accepted the conversation's message request
+func MarkConversationRead(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+
+		var request struct {
+			MessageID string `json:"message_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		messageID, err := uuid.Parse(request.MessageID)
+		if err != nil {
+			http.Error(w, "Invalid message ID", http.StatusBadRequest)
+			return
+		}
+
+		updates, err := types.MarkRead(r.Context(), db.Db, user.UserID, conv.ConversationID, messageID.String())
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to mark conversation read", http.StatusInternalServerError)
+			return
+		}
+
+		// Requesters don't learn whether their request was read
+		if conv.RequestState != types.RequestAccepted && conv.RequestedBy != nil && *conv.RequestedBy != user.UserID {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		settings, err := types.ReadPrivacySettings(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to load privacy settings")
+		} else if settings.ReadReceipts {
+			if err := hub.PublishReceipts(r.Context(), user.UserID, types.ReceiptRead, updates); err != nil {
+				logrus.WithError(err).Error("Failed to publish read receipts")
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ListMessageReceipts returns the delivery and read status of the current user's message for each recipient
+func ListMessageReceipts(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+
+		messageID, err := uuid.Parse(chi.URLParam(r, "messageID"))
+		if err != nil {
+			http.Error(w, "Invalid message ID", http.StatusBadRequest)
+			return
+		}
+
+		var message types.Message
+		if err := message.Read(r.Context(), db.Db, messageID); err != nil || message.ConversationID != conv.ConversationID || message.SenderID != user.UserID {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+
+		receipts, err := types.ListReceipts(r.Context(), db.Db, message.MessageID)
+		if err != nil {
+			http.Error(w, "Failed to load receipts", http.StatusInternalServerError)
+			return
+		}
+		if receipts == nil {
+			receipts = []types.MessageReceipt{}
+		}
+
+		writeJSON(w, http.StatusOK, receipts)
+	}
+}
+
+// loadMessage reads the message in the URL from conv as userID sees it, writing a 404 when they can't see it
+func loadMessage(w http.ResponseWriter, r *http.Request, db *storage.DB, conv *types.Membership, userID string) (*types.Message, bool) {
+	messageID, ok := urlID(r, "messageID")
+	if !ok {
+		http.Error(w, "Invalid message ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	message, err := types.ReadVisibleMessage(r.Context(), db.Db, conv.ConversationID, messageID, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, "Failed to load message", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &message, true
+}
+
+// loadOwnMessage reads the message in the URL for its sender to change, writing an error unless the current user
+// sent it, is still in the conversation and the message hasn't been unsent
+func loadOwnMessage(w http.ResponseWriter, r *http.Request, db *storage.DB, userID string) (*types.Membership, *types.Message, bool) {
+	conv, ok := loadConversation(w, r, db, userID)
+	if !ok {
+		return nil, nil, false
+	}
+	message, ok := loadMessage(w, r, db, conv, userID)
+	if !ok {
+		return nil, nil, false
+	}
+	if message.SenderID != userID {
+		http.Error(w, "Only the sender can change this message", http.StatusForbidden)
+		return nil, nil, false
+	}
+	if !conv.Active() {
+		http.Error(w, "You are no longer in this conversation", http.StatusForbidden)
+		return nil, nil, false
+	}
+	if message.UnsentAt != nil {
+		http.Error(w, "Message was unsent", http.StatusGone)
+		return nil, nil, false
+	}
+	return conv, message, true
+}
+
+// publishMessageChange tells every connected device of the conversation's participants that a message changed
+func publishMessageChange(r *http.Request, db *storage.DB, hub *realtime.Hub, userID string, event realtime.Event) {
+	recipients, err := types.ListParticipantIDs(r.Context(), db.Db, event.ConversationID, userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load conversation participants")
+		return
+	}
+	if err := hub.Publish(r.Context(), append(recipients, userID), event); err != nil {
+		logrus.WithError(err).Error("Failed to publish message change")
+	}
+}
+
+// EditMessage changes the content of one of the current user's text messages within the edit window.
+// The message keeps its place in the conversation and is marked as edited.
+func EditMessage(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		request.Content = strings.TrimSpace(request.Content)
+		if request.Content == "" || len(request.Content) > maxMessageLength {
+			http.Error(w, "Invalid message content", http.StatusBadRequest)
+			return
+		}
+
+		conv, message, ok := loadOwnMessage(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+		if !message.Editable(time.Now()) {
+			http.Error(w, "Message can no longer be edited", http.StatusForbidden)
+			return
+		}
+		if message.Content == request.Content {
+			writeJSON(w, http.StatusOK, message)
+			return
+		}
+		// Like new messages, edits can't reach someone in a block with the sender
+		if conv.Kind == types.ConversationDirect && message.ReceiverID != nil {
+			allowed, err := types.CanInteract(r.Context(), db.Db, user.UserID, *message.ReceiverID)
+			if err != nil {
+				http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+				return
+			}
+			if !allowed {
+				http.Error(w, "Conversation not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		if err := message.Edit(r.Context(), db.Db, request.Content); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Message was unsent", http.StatusGone)
+				return
+			}
+			http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+			return
+		}
+
+		publishMessageChange(r, db, hub, user.UserID, realtime.Event{
+			Type:           realtime.EventMessageEdited,
+			ConversationID: message.ConversationID,
+			MessageID:      message.MessageID,
+		})
+
+		writeJSON(w, http.StatusOK, message)
+	}
+}
+
+// UnsendMessage removes one of the current user's messages for everyone in the conversation, leaving a tombstone
+func UnsendMessage(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		_, message, ok := loadOwnMessage(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+
+		if err := message.Unsend(r.Context(), db.Db); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Message was unsent", http.StatusGone)
+				return
+			}
+			http.Error(w, "Failed to unsend message", http.StatusInternalServerError)
+			return
+		}
+
+		publishMessageChange(r, db, hub, user.UserID, realtime.Event{
+			Type:           realtime.EventMessageDeleted,
+			ConversationID: message.ConversationID,
+			MessageID:      message.MessageID,
+			Data:           message,
+		})
+
+		writeJSON(w, http.StatusOK, message)
+	}
+}
+
+// HideMessage deletes a message for the current user only, and removes it from their other devices
+func HideMessage(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+		message, ok := loadMessage(w, r, db, conv, user.UserID)
+		if !ok {
+			return
+		}
+
+		if err := types.HideMessage(r.Context(), db.Db, user.UserID, message.MessageID); err != nil {
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+
+		event := realtime.Event{Type: realtime.EventMessageDeleted, ConversationID: message.ConversationID, MessageID: message.MessageID}
+		if err := hub.Publish(r.Context(), []string{user.UserID}, event); err != nil {
+			logrus.WithError(err).Error("Failed to publish message change")
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// maxSearchLength is the longest message search query accepted
+const maxSearchLength = 200
+
+// SearchMessages searches the content of the messages the current user can see, across all of their conversations
+// or within the one given by conversation_id, newest first
+func SearchMessages(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" || len(query) > maxSearchLength {
+			http.Error(w, "Invalid search query", http.StatusBadRequest)
+			return
+		}
+
+		var conversationID *string
+		if raw := r.URL.Query().Get("conversation_id"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				http.Error(w, "Invalid conversation ID", http.StatusBadRequest)
+				return
+			}
+			conv, err := types.ReadConversation(r.Context(), db.Db, id.String(), user.UserID)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, "Conversation not found", http.StatusNotFound)
+					return
+				}
+				http.Error(w, "Failed to load conversation", http.StatusInternalServerError)
+				return
+			}
+			conversationID = &conv.ConversationID
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := types.SearchMessages(r.Context(), db.Db, user.UserID, query, conversationID, cursor, queryInt(r, "limit", 20, 50))
+		if err != nil {
+			http.Error(w, "Failed to search messages", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
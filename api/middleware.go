@@ -57,6 +57,19 @@ func SessionMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// WebSocketTokenMiddleware accepts the session token from the token query parameter, since browsers cannot set
+// headers on WebSocket upgrade requests. It must run before SessionMiddleware.
+func WebSocketTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("token"); token != "" {
+				r.Header.Set("Authorization", token)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // RequestMiddleware handles adding request data to the context.
 func RequestMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
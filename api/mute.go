@@ -0,0 +1,111 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CreateMute mutes a user, keyword or hashtag for the current user, optionally for a duration such as "24h"
+func CreateMute(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			Kind     string `json:"kind"`
+			Value    string `json:"value"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		mute := types.Mute{
+			MuteID:    uuid.New().String(),
+			UserID:    user.UserID,
+			Kind:      request.Kind,
+			Value:     request.Value,
+			CreatedAt: time.Now(),
+		}
+		if request.Duration != "" {
+			duration, err := time.ParseDuration(request.Duration)
+			if err != nil || duration <= 0 {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+			expiresAt := mute.CreatedAt.Add(duration)
+			mute.ExpiresAt = &expiresAt
+		}
+		if err := mute.Normalize(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := mute.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to create mute", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, mute)
+	}
+}
+
+// ListMutes returns the current user's active mutes
+func ListMutes(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		mutes, err := types.ListMutes(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load mutes", http.StatusInternalServerError)
+			return
+		}
+		if mutes == nil {
+			mutes = []types.Mute{}
+		}
+
+		writeJSON(w, http.StatusOK, mutes)
+	}
+}
+
+// DeleteMute removes one of the current user's mutes
+func DeleteMute(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		muteID, ok := urlID(r, "muteID")
+		if !ok {
+			http.Error(w, "Invalid mute ID", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := types.DeleteMute(r.Context(), db.Db, muteID, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to delete mute", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Mute not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
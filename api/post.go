@@ -0,0 +1,408 @@
+package api
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+)
+
+// CreatePost creates a new post for the current user
+func CreatePost(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var post types.Post
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		// Reposts and quotes go through the repost endpoint, which checks the original can be shared
+		post.OriginalPostID = nil
+		post.Original = nil
+
+		// A publish time without an explicit status means the post is scheduled
+		if post.Visibility == "" {
+			post.Visibility = types.VisibilityPublic
+		}
+		if post.Status == "" {
+			post.Status = types.PostPublished
+			if post.PublishAt != nil {
+				post.Status = types.PostScheduled
+			}
+		}
+
+		if err := post.Validate(); err != nil {
+			http.Error(w, "Invalid post data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		post.PostID = uuid.New().String()
+		post.UserID = author.UserID
+		post.CreatedAt = time.Now()
+
+		if err := post.Create(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to create post", http.StatusInternalServerError)
+			return
+		}
+		bus.EmitMentions(&post)
+
+		writeJSON(w, http.StatusCreated, post)
+	}
+}
+
+// ListUserPosts returns the posts on a user's profile
+func ListUserPosts(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		limit := queryInt(r, "limit", 20, 100)
+		offset := queryInt(r, "offset", 0, 10000)
+
+		posts, err := types.ListPostsByUser(r.Context(), db.Db, userID, viewer.UserID, limit, offset)
+		if err == nil {
+			err = hydratePosts(r, db, viewer.UserID, postPtrs(posts)...)
+		}
+		if err != nil {
+			http.Error(w, "Failed to load posts", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, posts)
+	}
+}
+
+// ListTagPosts returns the live posts with a hashtag
+func ListTagPosts(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		limit := queryInt(r, "limit", 20, 100)
+		offset := queryInt(r, "offset", 0, 10000)
+
+		posts, err := types.ListPostsByTag(r.Context(), db.Db, chi.URLParam(r, "tag"), viewer.UserID, limit, offset)
+		if err == nil {
+			err = hydratePosts(r, db, viewer.UserID, postPtrs(posts)...)
+		}
+		if err != nil {
+			http.Error(w, "Failed to load posts", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, posts)
+	}
+}
+
+// Feed returns the current user's home feed
+func Feed(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		limit := queryInt(r, "limit", 20, 100)
+		offset := queryInt(r, "offset", 0, 10000)
+
+		posts, err := types.ListFeed(r.Context(), db.Db, viewer.UserID, limit, offset)
+		if err == nil {
+			err = hydratePosts(r, db, viewer.UserID, postPtrs(posts)...)
+		}
+		if err != nil {
+			http.Error(w, "Failed to load feed", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, posts)
+	}
+}
+
+// ListDrafts returns the current user's drafts and scheduled posts
+func ListDrafts(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		status := r.URL.Query().Get("status")
+		if status != "" && status != types.PostDraft && status != types.PostScheduled {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		posts, err := types.ListUnpublished(r.Context(), db.Db, author.UserID, status)
+		if err != nil {
+			http.Error(w, "Failed to load drafts", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, posts)
+	}
+}
+
+// PatchDraft edits a draft or scheduled post, including rescheduling or unscheduling it
+func PatchDraft(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var post types.Post
+		if err := post.ReadOwned(r.Context(), db.Db, postID, author.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+		if post.Status == types.PostPublished {
+			http.Error(w, "Post is already published", http.StatusConflict)
+			return
+		}
+
+		// Decode over the stored post so omitted fields keep their values
+		if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		post.PostID = postID
+		post.UserID = author.UserID
+		if post.Status == types.PostPublished {
+			http.Error(w, "Use the publish endpoint to publish a draft", http.StatusBadRequest)
+			return
+		}
+		if post.Status == types.PostDraft {
+			post.PublishAt = nil
+		}
+
+		if err := post.Validate(); err != nil {
+			http.Error(w, "Invalid post data: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		updated, err := post.UpdateUnpublished(r.Context(), db.Db)
+		if err != nil {
+			http.Error(w, "Failed to update post", http.StatusInternalServerError)
+			return
+		}
+		if !updated {
+			http.Error(w, "Post is already published", http.StatusConflict)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, post)
+	}
+}
+
+// PublishDraft publishes a draft or scheduled post immediately
+func PublishDraft(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var post types.Post
+		if err := post.ReadOwned(r.Context(), db.Db, postID, author.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+
+		published, err := post.Publish(r.Context(), db.Db)
+		if err != nil {
+			http.Error(w, "Failed to publish post", http.StatusInternalServerError)
+			return
+		}
+		if !published {
+			http.Error(w, "Post is already published", http.StatusConflict)
+			return
+		}
+		bus.EmitMentions(&post)
+
+		writeJSON(w, http.StatusOK, post)
+	}
+}
+
+// DeleteDraft cancels a scheduled post or discards a draft
+func DeleteDraft(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		author, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := types.DeleteUnpublished(r.Context(), db.Db, postID, author.UserID)
+		if err != nil {
+			http.Error(w, "Failed to delete post", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Repost shares another user's post with the current user's followers. A non-empty content makes it a quote post.
+func Repost(db *storage.DB, bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reposter, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		var request struct {
+			Content string `json:"content,omitempty"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var original types.Post
+		if err := original.ReadVisible(r.Context(), db.Db, postID, reposter.UserID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Post not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+		if err := types.AttachOriginals(r.Context(), db.Db, &original); err != nil {
+			http.Error(w, "Failed to load post", http.StatusInternalServerError)
+			return
+		}
+
+		// Reposting a plain repost shares the post that was originally shared; a quote post is shared as itself
+		if original.Target() != original.PostID && original.Original != nil {
+			original = *original.Original
+		}
+		if original.Visibility != types.VisibilityPublic {
+			http.Error(w, "Only public posts can be reposted", http.StatusForbidden)
+			return
+		}
+		if original.UserID == reposter.UserID && request.Content == "" {
+			http.Error(w, "Cannot repost your own post", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, reposter.UserID, original.UserID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+
+		repost := types.NewRepost(&original, reposter.UserID, request.Content)
+		if err := repost.Create(r.Context(), db.Db); err != nil {
+			if storage.IsUniqueViolation(err) {
+				http.Error(w, "Post already reposted", http.StatusConflict)
+				return
+			}
+			http.Error(w, "Failed to repost", http.StatusInternalServerError)
+			return
+		}
+		bus.EmitMentions(repost)
+
+		repost.Original = &original
+		writeJSON(w, http.StatusCreated, repost)
+	}
+}
+
+// UndoRepost removes the current user's plain repost of a post
+func UndoRepost(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reposter, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		postID, ok := urlID(r, "postID")
+		if !ok {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := types.DeleteRepost(r.Context(), db.Db, postID, reposter.UserID)
+		if err != nil {
+			http.Error(w, "Failed to remove repost", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Repost not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// ChatSocket upgrades to the realtime WebSocket for the session user, who must be the user in the URL.
+// Passing resume_from with the last message ID received replays anything missed while disconnected.
+func ChatSocket(db *storage.DB, hub *realtime.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if userID != user.UserID {
+			http.Error(w, "Cannot connect as another user", http.StatusForbidden)
+			return
+		}
+
+		resumeFrom := r.URL.Query().Get("resume_from")
+		if resumeFrom != "" {
+			if _, err := uuid.Parse(resumeFrom); err != nil {
+				http.Error(w, "Invalid resume_from message ID", http.StatusBadRequest)
+				return
+			}
+		}
+
+		hub.Serve(w, r, user.UserID, resumeFrom)
+	}
+}
+
+// GetPresence returns whether a user is online and when they were last seen
+func GetPresence(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, viewer.UserID, userID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		presence, err := types.ReadPresence(r.Context(), db.Db, userID)
+		if err != nil {
+			http.Error(w, "Failed to load presence", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, presence)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"net/http"
+)
+
+// ListMessageRequests returns a page of the message requests waiting for the current user
+func ListMessageRequests(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		cursor, err := types.DecodeCursor(r.URL.Query().Get("cursor"), true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := types.ListRequests(r.Context(), db.Db, user.UserID, cursor, queryInt(r, "limit", 20, 50))
+		if err != nil {
+			http.Error(w, "Failed to load message requests", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// AcceptMessageRequest moves a message request into the current user's inbox, letting the sender message freely
+func AcceptMessageRequest(db *storage.DB) http.HandlerFunc {
+	return respondToRequest(db, types.RequestAccepted, false)
+}
+
+// DeclineMessageRequest hides a message request from the current user and stops the sender adding to it
+func DeclineMessageRequest(db *storage.DB) http.HandlerFunc {
+	return respondToRequest(db, types.RequestDeclined, false)
+}
+
+// DeclineAndBlockMessageRequest declines a message request and blocks its sender
+func DeclineAndBlockMessageRequest(db *storage.DB) http.HandlerFunc {
+	return respondToRequest(db, types.RequestDeclined, true)
+}
+
+// respondToRequest returns a handler that moves a message request sent to the current user into state,
+// blocking the sender as well when block is set
+func respondToRequest(db *storage.DB, state string, block bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		conv, ok := loadConversation(w, r, db, user.UserID)
+		if !ok {
+			return
+		}
+
+		updated, err := types.RespondToRequest(r.Context(), db.Db, conv.ConversationID, user.UserID, state)
+		if err != nil {
+			http.Error(w, "Failed to update message request", http.StatusInternalServerError)
+			return
+		}
+		if !updated {
+			http.Error(w, "Message request not found", http.StatusNotFound)
+			return
+		}
+
+		if block {
+			blocked := types.BlockedUser{BlockerID: user.UserID, BlockedUserID: *conv.RequestedBy}
+			if err := blocked.Create(r.Context(), db.Db); err != nil {
+				http.Error(w, "Failed to block user", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"encoding/json"
+	"net/http"
+)
+
+// GetPrivacySettings returns the current user's privacy settings
+func GetPrivacySettings(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		settings, err := types.ReadPrivacySettings(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load privacy settings", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
+
+// UpdatePrivacySettings changes the current user's privacy settings. Omitted fields keep their values.
+func UpdatePrivacySettings(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		settings, err := types.ReadPrivacySettings(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load privacy settings", http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		settings.UserID = user.UserID
+
+		if err := settings.Save(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to save privacy settings", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
+
+// GetNotificationSettings returns the current user's notification preferences for every category
+func GetNotificationSettings(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		settings, err := types.ReadNotificationSettings(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load notification settings", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
+
+// UpdateNotificationSettings changes the current user's notification preferences, keyed by category.
+// Omitted categories and fields keep their values.
+func UpdateNotificationSettings(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		var request map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		settings, err := types.ReadNotificationSettings(r.Context(), db.Db, user.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load notification settings", http.StatusInternalServerError)
+			return
+		}
+		for category, raw := range request {
+			pref, ok := settings[category]
+			if !ok {
+				http.Error(w, "Unknown notification category "+category, http.StatusBadRequest)
+				return
+			}
+			if err := json.Unmarshal(raw, pref); err != nil {
+				http.Error(w, "Invalid request payload", http.StatusBadRequest)
+				return
+			}
+			if err := pref.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := settings.Save(r.Context(), db.Db); err != nil {
+			http.Error(w, "Failed to save notification settings", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, settings)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"net/http"
+)
+
+// ListSuggestions returns "people you may know" for the current user from the precomputed suggestions
+func ListSuggestions(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		suggestions, err := types.ListSuggestions(r.Context(), db.Db, user.UserID, queryInt(r, "limit", 20, 50))
+		if err != nil {
+			http.Error(w, "Failed to load suggestions", http.StatusInternalServerError)
+			return
+		}
+		if suggestions == nil {
+			suggestions = []types.SuggestedUser{}
+		}
+
+		writeJSON(w, http.StatusOK, suggestions)
+	}
+}
+
+// DismissSuggestion stops a user from being suggested to the current user again
+func DismissSuggestion(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		candidateID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		if err := types.DismissSuggestion(r.Context(), db.Db, user.UserID, candidateID); err != nil {
+			if storage.IsForeignKeyViolation(err) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to dismiss suggestion", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -3,7 +3,9 @@ package api
 import (
 	"Engine/storage"
 	"Engine/types"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 	"github.com/go-playground/validator/v10"
@@ -113,7 +115,7 @@ func Exist(db *storage.DB) http.HandlerFunc {
 		}
 
 		if request.Email != "" {
-			emailExists, err := user.EmailExist(r.Context(), db.db)
+			emailExists, err := user.EmailExist(r.Context(), db.Db)
 			if err != nil {
 				http.Error(w, "Failed to check email", http.StatusInternalServerError)
 				return
@@ -169,3 +171,46 @@ func PatchAccount(db *storage.DB) http.HandlerFunc {
 		}
 	}
 }
+
+// GetProfile returns a user's profile with follow counts and their relationship to the current user
+func GetProfile(db *storage.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		viewer, err := currentUser(r, db)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusUnauthorized)
+			return
+		}
+
+		userID, ok := urlID(r, "userID")
+		if !ok {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		allowed, err := types.CanInteract(r.Context(), db.Db, viewer.UserID, userID)
+		if err != nil {
+			http.Error(w, "Failed to check blocks", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		writeProfile(w, r, db, userID, viewer.UserID)
+	}
+}
+
+// writeProfile responds with userID's profile as seen by viewerID
+func writeProfile(w http.ResponseWriter, r *http.Request, db *storage.DB, userID, viewerID string) {
+	profile, err := types.ReadProfile(r.Context(), db.Db, userID, viewerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to load profile", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, profile)
+}
@@ -0,0 +1,96 @@
+package events
+
+import (
+	"Engine/types"
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Domain event types
+const (
+	PostLiked      = "post_liked"
+	CommentCreated = "comment_created"
+	CommentReplied = "comment_replied"
+	CommentLiked   = "comment_liked"
+	UserFollowed   = "user_followed"
+	UserMentioned  = "user_mentioned"
+	MessageSent    = "message_sent"
+)
+
+// Event is something a user did that others may want to hear about
+type Event struct {
+	Type     string
+	ActorID  string // the user who acted
+	UserID   string // the user acted on: the author of the post or comment, or the user followed, mentioned or messaged
+	TargetID string // the post, comment or conversation acted on, if any
+	Text     string // the text involved, such as a comment, for previews and keyword mutes
+}
+
+// Handler reacts to an event. Handlers run on the bus's workers and log their own errors.
+type Handler func(ctx context.Context, event Event)
+
+// Bus delivers events to the handlers subscribed to their type in the background, so that emitting never slows down a request
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	queue    chan Event
+}
+
+// NewBus returns a bus that holds up to size undelivered events
+func NewBus(size int) *Bus {
+	return &Bus{handlers: make(map[string][]Handler), queue: make(chan Event, size)}
+}
+
+// Subscribe registers handler for events of the given types
+func (b *Bus) Subscribe(handler Handler, eventTypes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range eventTypes {
+		b.handlers[t] = append(b.handlers[t], handler)
+	}
+}
+
+// Emit queues an event for delivery. Events are dropped with a warning rather than blocking when the queue is full.
+func (b *Bus) Emit(event Event) {
+	select {
+	case b.queue <- event:
+	default:
+		logrus.Warnf("Event queue full, dropping %s event", event.Type)
+	}
+}
+
+// EmitMentions tells the users newly mentioned in a post that just went live. Which mentions count, for example
+// that users in a block with the author are never mentioned, is decided when the post is published.
+func (b *Bus) EmitMentions(post *types.Post) {
+	for _, userID := range post.MentionedIDs {
+		b.Emit(Event{Type: UserMentioned, ActorID: post.UserID, UserID: userID, TargetID: post.PostID})
+	}
+}
+
+// Start delivers queued events on workers goroutines until ctx is cancelled
+func (b *Bus) Start(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-b.queue:
+					b.deliver(ctx, event)
+				}
+			}
+		}()
+	}
+}
+
+// deliver runs every handler subscribed to the event's type
+func (b *Bus) deliver(ctx context.Context, event Event) {
+	b.mu.RLock()
+	handlers := b.handlers[event.Type]
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(ctx, event)
+	}
+}
@@ -19,7 +19,7 @@ CREATE TABLE IF NOT EXISTS users (
   salt BYTEA,
   latitude DECIMAL(9,6),
   longitude DECIMAL(9,6),
-  session_token VARCHAR(255), 
+  session_token VARCHAR(255)
 );
 
 CREATE TABLE IF NOT EXISTS followings (
@@ -70,6 +70,41 @@ CREATE TABLE IF NOT EXISTS comments (
     FOREIGN KEY (post_id) REFERENCES posts(post_id)
 );
 
+ALTER TABLE followings ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT NOW();
+ALTER TABLE followings DROP CONSTRAINT IF EXISTS followings_not_self_check;
+ALTER TABLE followings ADD CONSTRAINT followings_not_self_check CHECK (follower_id <> following_id) NOT VALID;
+
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ; -- NULL for posts that never expire
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS status VARCHAR(10) NOT NULL DEFAULT 'published'; -- published, draft or scheduled
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS publish_at TIMESTAMPTZ; -- set for scheduled posts
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS original_post_id UUID REFERENCES posts(post_id) ON DELETE CASCADE; -- set for reposts and quote posts
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS visibility VARCHAR(15) NOT NULL DEFAULT 'public'; -- public, followers or close_friends
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS like_count INTEGER NOT NULL DEFAULT 0; -- maintained alongside likes, repaired by a periodic job
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comments_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS pinned_comment_id UUID; -- references comments; set by the post owner
+
+ALTER TABLE likes ADD COLUMN IF NOT EXISTS reaction VARCHAR(20) NOT NULL DEFAULT 'heart'; -- one of the configured REACTION_TYPES
+
+-- Likes used to allow repeats; keep each user's earliest like of a post before enforcing one per post
+DELETE FROM likes WHERE like_id IN (
+    SELECT like_id FROM (
+        SELECT like_id, ROW_NUMBER() OVER (PARTITION BY user_id, post_id ORDER BY created_at NULLS LAST, like_id) AS n FROM likes
+    ) ranked WHERE n > 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS likes_user_post_idx ON likes (user_id, post_id);
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_comment_id UUID REFERENCES comments(comment_id); -- set for replies; threads are one level deep
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS like_count INTEGER NOT NULL DEFAULT 0; -- maintained alongside comment_likes
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ; -- deleted comments are kept as placeholders so threads stay intact
+
+-- Likes and comments go with their post, including reposts and quotes removed through original_post_id
+ALTER TABLE likes DROP CONSTRAINT IF EXISTS likes_post_id_fkey;
+ALTER TABLE likes ADD CONSTRAINT likes_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_post_id_fkey;
+ALTER TABLE comments ADD CONSTRAINT comments_post_id_fkey FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE;
+
 CREATE TABLE IF NOT EXISTS notifications  ( -- (e.g., "like", "comment", "follow", etc.).
     notification_id UUID PRIMARY KEY,
     user_id UUID NOT NULL,
@@ -102,3 +137,289 @@ CREATE TABLE IF NOT EXISTS messages (
     FOREIGN KEY (sender_id) REFERENCES users(user_id),
     FOREIGN KEY (receiver_id) REFERENCES users(user_id)
 );
+
+CREATE TABLE IF NOT EXISTS post_views (
+    user_id UUID NOT NULL,
+    post_id UUID NOT NULL,
+    viewed_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS posts_created_at_idx ON posts (created_at DESC);
+CREATE INDEX IF NOT EXISTS likes_post_id_idx ON likes (post_id, created_at);
+CREATE INDEX IF NOT EXISTS comments_post_id_idx ON comments (post_id, created_at);
+CREATE INDEX IF NOT EXISTS posts_expires_at_idx ON posts (expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS posts_publish_at_idx ON posts (publish_at) WHERE status = 'scheduled';
+
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id UUID PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id UUID NOT NULL,
+    tag_id UUID NOT NULL,
+    PRIMARY KEY (post_id, tag_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS post_mentions (
+    post_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    PRIMARY KEY (post_id, user_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS feed_items (
+    user_id UUID NOT NULL,
+    post_id UUID NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, post_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS feed_items_user_idx ON feed_items (user_id, created_at DESC);
+
+-- a user can plainly repost a post once; quote posts are unrestricted
+CREATE UNIQUE INDEX IF NOT EXISTS posts_repost_unique_idx ON posts (user_id, original_post_id) WHERE original_post_id IS NOT NULL AND COALESCE(content, '') = '';
+
+CREATE TABLE IF NOT EXISTS close_friends (
+    user_id UUID NOT NULL,
+    friend_id UUID NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, friend_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id),
+    FOREIGN KEY (friend_id) REFERENCES users(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS post_reaction_counts (
+    post_id UUID NOT NULL,
+    reaction VARCHAR(20) NOT NULL,
+    count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (post_id, reaction),
+    FOREIGN KEY (post_id) REFERENCES posts(post_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comments_top_level_idx ON comments (post_id, created_at DESC, comment_id DESC) WHERE parent_comment_id IS NULL;
+CREATE INDEX IF NOT EXISTS comments_replies_idx ON comments (parent_comment_id, created_at, comment_id) WHERE parent_comment_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS comment_edits (
+    edit_id UUID PRIMARY KEY,
+    comment_id UUID NOT NULL,
+    content TEXT NOT NULL,
+    written_at TIMESTAMPTZ NOT NULL, -- when this version was originally written
+    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comment_edits_comment_idx ON comment_edits (comment_id, written_at);
+
+CREATE TABLE IF NOT EXISTS comment_likes (
+    comment_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id),
+    FOREIGN KEY (comment_id) REFERENCES comments(comment_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS comments_top_idx ON comments (post_id, like_count DESC, created_at DESC, comment_id DESC) WHERE parent_comment_id IS NULL;
+
+ALTER TABLE posts DROP CONSTRAINT IF EXISTS posts_pinned_comment_fk;
+ALTER TABLE posts ADD CONSTRAINT posts_pinned_comment_fk FOREIGN KEY (pinned_comment_id) REFERENCES comments(comment_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS followings_following_idx ON followings (following_id, created_at DESC, follower_id DESC);
+CREATE INDEX IF NOT EXISTS followings_follower_idx ON followings (follower_id, created_at DESC, following_id DESC);
+
+CREATE TABLE IF NOT EXISTS follow_suggestions (
+    user_id UUID NOT NULL,
+    candidate_id UUID NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    mutuals INTEGER NOT NULL DEFAULT 0,
+    shared_tags INTEGER NOT NULL DEFAULT 0,
+    distance_km DOUBLE PRECISION,
+    computed_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, candidate_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (candidate_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS follow_suggestions_score_idx ON follow_suggestions (user_id, score DESC);
+
+CREATE TABLE IF NOT EXISTS suggestion_dismissals (
+    user_id UUID NOT NULL,
+    candidate_id UUID NOT NULL,
+    dismissed_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, candidate_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (candidate_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS users_location_idx ON users (latitude, longitude);
+
+CREATE TABLE IF NOT EXISTS mutes (
+    mute_id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('user', 'keyword', 'hashtag')),
+    value VARCHAR(100) NOT NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (user_id, kind, value),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS conversations (
+    conversation_id UUID PRIMARY KEY,
+    kind VARCHAR(10) NOT NULL DEFAULT 'direct',
+    direct_key VARCHAR(73) UNIQUE, -- sorted participant IDs of a direct conversation
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    last_message_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS conversation_participants (
+    conversation_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    joined_at TIMESTAMPTZ DEFAULT NOW(),
+    last_read_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (conversation_id, user_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(conversation_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS conversation_participants_user_idx ON conversation_participants (user_id);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS conversation_id UUID REFERENCES conversations(conversation_id) ON DELETE CASCADE;
+
+-- Move messages sent before conversations existed into direct conversations
+INSERT INTO conversations (conversation_id, kind, direct_key, created_at, last_message_at)
+SELECT md5(legacy.direct_key)::uuid, 'direct', legacy.direct_key, MIN(legacy.timestamp), MAX(legacy.timestamp)
+FROM (SELECT LEAST(sender_id, receiver_id)::text || ':' || GREATEST(sender_id, receiver_id)::text AS direct_key, timestamp
+      FROM messages WHERE conversation_id IS NULL) legacy
+GROUP BY legacy.direct_key
+ON CONFLICT (direct_key) DO NOTHING;
+
+UPDATE messages m SET conversation_id = c.conversation_id
+FROM conversations c
+WHERE m.conversation_id IS NULL AND c.direct_key = LEAST(m.sender_id, m.receiver_id)::text || ':' || GREATEST(m.sender_id, m.receiver_id)::text;
+
+INSERT INTO conversation_participants (conversation_id, user_id, joined_at, last_read_at)
+SELECT c.conversation_id, split_part(c.direct_key, ':', n)::uuid, c.created_at, c.last_message_at
+FROM conversations c CROSS JOIN (VALUES (1), (2)) AS side(n)
+WHERE c.direct_key IS NOT NULL
+ON CONFLICT (conversation_id, user_id) DO NOTHING;
+
+ALTER TABLE messages ALTER COLUMN conversation_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS messages_conversation_idx ON messages (conversation_id, timestamp DESC, message_id DESC);
+
+CREATE TABLE IF NOT EXISTS user_presence (
+    user_id UUID PRIMARY KEY,
+    connections INTEGER NOT NULL DEFAULT 0,
+    last_seen_at TIMESTAMPTZ,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS message_receipts (
+    message_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    delivered_at TIMESTAMPTZ,
+    read_at TIMESTAMPTZ,
+    PRIMARY KEY (message_id, user_id),
+    FOREIGN KEY (message_id) REFERENCES messages(message_id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS message_receipts_pending_idx ON message_receipts (user_id, message_id) WHERE read_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS privacy_settings (
+    user_id UUID PRIMARY KEY,
+    read_receipts BOOLEAN NOT NULL DEFAULT TRUE,
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS name VARCHAR(100);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(255);
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(user_id) ON DELETE SET NULL;
+
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS role VARCHAR(10) NOT NULL DEFAULT 'member';
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS left_at TIMESTAMPTZ;
+
+-- Group messages have no single receiver
+ALTER TABLE messages ALTER COLUMN receiver_id DROP NOT NULL;
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS request_state VARCHAR(10) NOT NULL DEFAULT 'accepted';
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS requested_by UUID REFERENCES users(user_id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS media (
+    media_id UUID PRIMARY KEY,
+    uploader_id UUID NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('image', 'video', 'audio')),
+    content_type VARCHAR(50) NOT NULL,
+    size BIGINT NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    data BYTEA NOT NULL,
+    preview BYTEA,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    FOREIGN KEY (uploader_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS media_id UUID REFERENCES media(media_id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS shared_post_id UUID REFERENCES posts(post_id) ON DELETE SET NULL;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS location_name VARCHAR(100);
+
+-- Existing free-form content types are left as they are; new messages must use the enum
+ALTER TABLE messages DROP CONSTRAINT IF EXISTS messages_content_type_check;
+ALTER TABLE messages ADD CONSTRAINT messages_content_type_check
+    CHECK (content_type IN ('text', 'image', 'video', 'audio', 'location', 'post_share', 'system')) NOT VALID;
+
+CREATE INDEX IF NOT EXISTS messages_media_idx ON messages (media_id) WHERE media_id IS NOT NULL;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS unsent_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS message_hides (
+    user_id UUID NOT NULL,
+    message_id UUID NOT NULL,
+    hidden_at TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (user_id, message_id),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+    FOREIGN KEY (message_id) REFERENCES messages(message_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (to_tsvector('simple', content)) WHERE unsent_at IS NULL;
+
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS message_ttl INTEGER CHECK (message_ttl > 0);
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS messages_expires_at_idx ON messages (expires_at) WHERE expires_at IS NOT NULL;
+
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS actor_id UUID REFERENCES users(user_id) ON DELETE SET NULL;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS target_id UUID;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS actor_count INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS group_key VARCHAR(100);
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_unread_group_idx ON notifications (user_id, group_key) WHERE NOT is_read AND group_key IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id UUID NOT NULL,
+    actor_id UUID NOT NULL,
+    PRIMARY KEY (notification_id, actor_id),
+    FOREIGN KEY (notification_id) REFERENCES notifications(notification_id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id UUID NOT NULL,
+    category VARCHAR(20) NOT NULL CHECK (category IN ('likes', 'comments', 'follows', 'mentions', 'messages', 'moderation')),
+    in_app BOOLEAN NOT NULL,
+    push BOOLEAN NOT NULL,
+    email BOOLEAN NOT NULL,
+    audience VARCHAR(10) NOT NULL DEFAULT 'everyone' CHECK (audience IN ('everyone', 'following')),
+    PRIMARY KEY (user_id, category),
+    FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
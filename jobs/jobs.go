@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"Engine/events"
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Job is a unit of periodic background work.
+type Job func(ctx context.Context) error
+
+// Every runs job on a fixed interval until ctx is cancelled. Errors are logged and the job keeps running.
+func Every(ctx context.Context, name string, interval time.Duration, job Job) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		logrus.Infof("Starting job %s, running every %s", name, interval)
+		for {
+			if err := job(ctx); err != nil {
+				logrus.WithError(err).Errorf("Job %s failed", name)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Start launches every background job against db, publishing realtime events through hub and domain events on bus.
+// Intervals are configurable through the environment.
+func Start(ctx context.Context, db *storage.DB, hub *realtime.Hub, bus *events.Bus) {
+	Every(ctx, "sweep-expired-posts", types.EnvDuration("POST_SWEEP_INTERVAL", 10*time.Minute),
+		SweepExpiredPosts(db, types.EnvDuration("POST_EXPIRED_RETENTION", 24*time.Hour)))
+	Every(ctx, "publish-scheduled-posts", types.EnvDuration("POST_PUBLISH_INTERVAL", 30*time.Second),
+		PublishScheduledPosts(db, bus, types.EnvInt("POST_PUBLISH_BATCH", 100)))
+	Every(ctx, "compute-suggestions", types.EnvDuration("SUGGEST_INTERVAL", 6*time.Hour),
+		ComputeSuggestions(db, types.SuggestionWeightsFromEnv(), types.EnvInt("SUGGEST_BATCH", 200)))
+	Every(ctx, "reconcile-like-counts", types.EnvDuration("LIKE_RECONCILE_INTERVAL", time.Hour), ReconcileLikeCounts(db))
+	Every(ctx, "sweep-expired-mutes", types.EnvDuration("MUTE_SWEEP_INTERVAL", time.Hour), SweepExpiredMutes(db))
+	Every(ctx, "sweep-orphaned-media", types.EnvDuration("MEDIA_SWEEP_INTERVAL", time.Hour),
+		SweepOrphanedMedia(db, types.EnvDuration("MEDIA_ORPHAN_AGE", 24*time.Hour)))
+	Every(ctx, "sweep-expired-messages", types.EnvDuration("MESSAGE_SWEEP_INTERVAL", time.Minute),
+		SweepExpiredMessages(db, hub, types.EnvInt("MESSAGE_SWEEP_BATCH", 500)))
+}
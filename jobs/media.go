@@ -0,0 +1,24 @@
+package jobs
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SweepOrphanedMedia deletes uploads that were never attached to a message within age.
+func SweepOrphanedMedia(db *storage.DB, age time.Duration) Job {
+	return func(ctx context.Context) error {
+		deleted, err := types.DeleteOrphanedMedia(ctx, db.Db, age)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			logrus.Infof("Swept %d unattached uploads", deleted)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,48 @@
+package jobs
+
+import (
+	"Engine/realtime"
+	"Engine/storage"
+	"Engine/types"
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SweepExpiredMessages deletes disappearing messages whose timer has run out, batch at a time, and tells the
+// conversations' connected members to remove them. Reads already hide expired messages, so a slow sweep only delays cleanup.
+func SweepExpiredMessages(db *storage.DB, hub *realtime.Hub, batch int) Job {
+	return func(ctx context.Context) error {
+		for {
+			expired, err := types.DeleteExpiredMessages(ctx, db.Db, batch)
+			if err != nil {
+				return err
+			}
+			if len(expired) > 0 {
+				logrus.Infof("Swept %d expired messages", len(expired))
+			}
+
+			byConversation := make(map[string][]string)
+			for _, m := range expired {
+				byConversation[m.ConversationID] = append(byConversation[m.ConversationID], m.MessageID)
+			}
+			for conversationID, messageIDs := range byConversation {
+				members, err := types.ListMemberIDs(ctx, db.Db, conversationID)
+				if err != nil {
+					logrus.WithError(err).Error("Failed to load conversation members")
+					continue
+				}
+				for _, messageID := range messageIDs {
+					event := realtime.Event{Type: realtime.EventMessageDeleted, ConversationID: conversationID, MessageID: messageID}
+					if err := hub.Publish(ctx, members, event); err != nil {
+						logrus.WithError(err).Error("Failed to publish expired message")
+					}
+				}
+			}
+
+			if len(expired) < batch {
+				return nil
+			}
+		}
+	}
+}
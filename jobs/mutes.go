@@ -0,0 +1,23 @@
+package jobs
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SweepExpiredMutes removes mutes whose duration has passed. Reads already ignore them, so this only keeps the table small.
+func SweepExpiredMutes(db *storage.DB) Job {
+	return func(ctx context.Context) error {
+		deleted, err := types.DeleteExpiredMutes(ctx, db.Db)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			logrus.Infof("Swept %d expired mutes", deleted)
+		}
+		return nil
+	}
+}
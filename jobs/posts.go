@@ -0,0 +1,62 @@
+package jobs
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SweepExpiredPosts hard-deletes ephemeral posts once they are past the retention window.
+func SweepExpiredPosts(db *storage.DB, retention time.Duration) Job {
+	return func(ctx context.Context) error {
+		deleted, err := types.DeleteExpiredPosts(ctx, db.Db, retention)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 {
+			logrus.Infof("Swept %d expired posts", deleted)
+		}
+		return nil
+	}
+}
+
+// ReconcileLikeCounts repairs post like counts that have drifted from the likes table.
+func ReconcileLikeCounts(db *storage.DB) Job {
+	return func(ctx context.Context) error {
+		fixed, err := types.ReconcileLikeCounts(ctx, db.Db)
+		if err != nil {
+			return err
+		}
+		if fixed > 0 {
+			logrus.Warnf("Repaired like counts on %d posts", fixed)
+		}
+		return nil
+	}
+}
+
+// PublishScheduledPosts publishes scheduled posts whose publish time has arrived.
+func PublishScheduledPosts(db *storage.DB, bus *events.Bus, batch int) Job {
+	return func(ctx context.Context) error {
+		due, err := types.ListDuePosts(ctx, db.Db, batch)
+		if err != nil {
+			return err
+		}
+		for _, post := range due {
+			// Publish is a no-op if another instance got to the post first
+			published, err := post.Publish(ctx, db.Db)
+			if err != nil {
+				logrus.WithError(err).Errorf("Failed to publish scheduled post %s", post.PostID)
+				continue
+			}
+			if !published {
+				continue
+			}
+			bus.EmitMentions(&post)
+		}
+		return nil
+	}
+}
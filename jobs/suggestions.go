@@ -0,0 +1,47 @@
+package jobs
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ComputeSuggestions precomputes follow suggestions for every user, batch users at a time.
+func ComputeSuggestions(db *storage.DB, weights types.SuggestionWeights, batch int) Job {
+	return func(ctx context.Context) error {
+		after := uuid.Nil.String()
+		computed := 0
+		for {
+			users, err := types.ListUsersAfter(ctx, db.Db, after, batch)
+			if err != nil {
+				return err
+			}
+			if len(users) == 0 {
+				break
+			}
+
+			for i := range users {
+				user := &users[i]
+				candidates, err := types.ListSuggestionCandidates(ctx, db.Db, user, weights)
+				if err != nil {
+					logrus.WithError(err).Errorf("Failed to gather suggestions for user %s", user.UserID)
+					continue
+				}
+				suggestions := types.RankSuggestions(user, candidates, weights, time.Now())
+				if err := types.ReplaceSuggestions(ctx, db.Db, user.UserID, suggestions); err != nil {
+					logrus.WithError(err).Errorf("Failed to store suggestions for user %s", user.UserID)
+					continue
+				}
+				computed++
+			}
+			after = users[len(users)-1].UserID
+		}
+
+		logrus.Infof("Computed follow suggestions for %d users", computed)
+		return nil
+	}
+}
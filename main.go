@@ -2,7 +2,13 @@ package main
 
 import (
 	"Engine/api"
+	"Engine/events"
+	"Engine/jobs"
+	"Engine/notify"
+	"Engine/realtime"
 	"Engine/storage"
+	"Engine/types"
+	"context"
 	"os"
 
 	"github.com/go-chi/chi"
@@ -29,9 +35,23 @@ func main() {
 
     logrus.Info("Established a successful database connection.")
 
+	// start realtime fan-out between server instances
+	hub := realtime.NewHub(db, DBConn)
+	if err := hub.Start(context.Background()); err != nil {
+		logrus.Fatal(err)
+	}
+
+	// start delivering domain events, such as likes and follows, to the notification service
+	bus := events.NewBus(types.EnvInt("EVENT_QUEUE_SIZE", 1024))
+	notify.NewService(db, notify.LogSender("push"), notify.LogSender("email")).Subscribe(bus)
+	bus.Start(context.Background(), types.EnvInt("EVENT_WORKERS", 4))
+
+	// start background jobs
+	jobs.Start(context.Background(), db, hub, bus)
+
 	// Initialize handlers
 	r := chi.NewRouter()
-	api.InitHandlers(r,db)
+	api.InitHandlers(r,db,hub,bus)
 
 }
 
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sender delivers notifications on a channel outside the app, such as push or email
+type Sender interface {
+	Send(ctx context.Context, userID, notificationType, content string) error
+}
+
+// logSender stands in for a channel with no provider configured by logging what it would have sent
+type logSender struct {
+	channel string
+}
+
+// LogSender returns a sender that only logs, for channels without a provider
+func LogSender(channel string) Sender {
+	return logSender{channel: channel}
+}
+
+// Send logs the notification
+func (s logSender) Send(ctx context.Context, userID, notificationType, content string) error {
+	logrus.WithFields(logrus.Fields{"channel": s.channel, "user_id": userID, "type": notificationType}).Debug(content)
+	return nil
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"Engine/events"
+	"Engine/storage"
+	"Engine/types"
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Notification types
+const (
+	TypePostLike    = "post_like"
+	TypeComment     = "comment"
+	TypeReply       = "reply"
+	TypeCommentLike = "comment_like"
+	TypeFollow      = "follow"
+	TypeMention     = "mention"
+	TypeMessage     = "message"
+)
+
+// previewLength is how much of a comment is quoted in its notification
+const previewLength = 80
+
+// Service turns domain events into notifications and delivers them on the channels each recipient chose
+type Service struct {
+	db    *storage.DB
+	push  Sender
+	email Sender
+}
+
+// NewService returns a notification service writing in-app notifications to db and sending the rest through push and email
+func NewService(db *storage.DB, push, email Sender) *Service {
+	return &Service{db: db, push: push, email: email}
+}
+
+// Subscribe registers the service for the events it notifies about
+func (s *Service) Subscribe(bus *events.Bus) {
+	bus.Subscribe(s.handle, events.PostLiked, events.CommentCreated, events.CommentReplied, events.CommentLiked,
+		events.UserFollowed, events.UserMentioned, events.MessageSent)
+}
+
+// notice describes the notification for an event. Likes, follows and messages are grouped, so that a popular post
+// gives its author one "alice and 12 others liked your post" rather than thirteen notifications.
+func notice(event events.Event) types.Notice {
+	n := types.Notice{UserID: event.UserID, ActorID: event.ActorID, TargetID: event.TargetID, Text: event.Text}
+	switch event.Type {
+	case events.PostLiked:
+		n.Type, n.Category, n.Action, n.GroupKey = TypePostLike, types.CategoryLikes, "liked your post", TypePostLike+":"+event.TargetID
+	case events.CommentLiked:
+		n.Type, n.Category, n.Action, n.GroupKey = TypeCommentLike, types.CategoryLikes, "liked your comment", TypeCommentLike+":"+event.TargetID
+	case events.UserFollowed:
+		n.Type, n.Category, n.Action, n.GroupKey = TypeFollow, types.CategoryFollows, "started following you", TypeFollow
+	case events.CommentCreated:
+		n.Type, n.Category, n.Action = TypeComment, types.CategoryComments, "commented on your post: "+preview(event.Text)
+	case events.CommentReplied:
+		n.Type, n.Category, n.Action = TypeReply, types.CategoryComments, "replied to your comment: "+preview(event.Text)
+	case events.UserMentioned:
+		n.Type, n.Category, n.Action = TypeMention, types.CategoryMentions, "mentioned you in a post"
+	case events.MessageSent:
+		n.Type, n.Category, n.Action, n.GroupKey = TypeMessage, types.CategoryMessages, "sent you a message", TypeMessage+":"+event.TargetID
+	}
+	return n
+}
+
+// preview shortens text for quoting in a notification
+func preview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= previewLength {
+		return text
+	}
+	return string(runes[:previewLength]) + "…"
+}
+
+// handle creates the notification for an event and sends it on the other channels the recipient wants
+func (s *Service) handle(ctx context.Context, event events.Event) {
+	delivery, err := types.Notify(ctx, s.db.Db, notice(event))
+	if err != nil {
+		logrus.WithError(err).Errorf("Failed to create notification for %s event", event.Type)
+		return
+	}
+	if delivery == nil {
+		return
+	}
+	if delivery.Push {
+		if err := s.push.Send(ctx, delivery.UserID, delivery.Type, delivery.Content); err != nil {
+			logrus.WithError(err).Error("Failed to send push notification")
+		}
+	}
+	if delivery.Email {
+		if err := s.email.Send(ctx, delivery.UserID, delivery.Type, delivery.Content); err != nil {
+			logrus.WithError(err).Error("Failed to send email notification")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package realtime
+
+import (
+	"Engine/types"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Connection timings. Clients must answer pings within pongWait, and each pong also refreshes presence.
+var (
+	pingInterval = types.EnvDuration("WS_PING_INTERVAL", 30*time.Second)
+	pongWait     = pingInterval * 2
+	writeWait    = 10 * time.Second
+)
+
+const (
+	// maxFrameSize bounds frames read from clients, which only send small control events
+	maxFrameSize = 4096
+	// sendBuffer is how many frames may queue for a slow client before it is dropped
+	sendBuffer = 64
+	// resumeLimit is the most missed messages replayed on reconnect before the client is told to resync
+	resumeLimit = 500
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     checkOrigin(types.EnvList("WS_ALLOWED_ORIGINS", nil)),
+}
+
+// checkOrigin allows the configured browser origins, or only same-origin and non-browser clients when none are set
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, a := range allowed {
+			if a == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Client is one device's realtime connection
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID string
+	send   chan []byte
+	done   chan struct{}
+}
+
+// inbound is a frame sent by a client
+type inbound struct {
+	Type           string `json:"type"`
+	ConversationID string `json:"conversation_id"`
+	Typing         *bool  `json:"typing"`
+}
+
+// Serve upgrades the request to a WebSocket for userID, replays messages after resumeFrom if set and then
+// streams events until the connection closes
+func (h *Hub) Serve(w http.ResponseWriter, r *http.Request, userID, resumeFrom string) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written the error response
+		return
+	}
+
+	// The connection outlives the request, so it gets its own context
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &Client{hub: h, conn: conn, userID: userID, send: make(chan []byte, sendBuffer), done: make(chan struct{})}
+	h.register(c)
+	if err := types.Connect(ctx, h.db.Db, userID); err != nil {
+		logrus.WithError(err).Error("Failed to record connection")
+	}
+	h.publishPresence(ctx, userID)
+
+	go c.writePump()
+	if resumeFrom != "" {
+		c.resume(ctx, resumeFrom)
+	}
+	c.readPump(ctx)
+
+	h.unregister(c)
+	close(c.done)
+	last, err := types.Disconnect(ctx, h.db.Db, userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to record disconnection")
+	}
+	if last {
+		h.publishPresence(ctx, userID)
+	}
+}
+
+// resume replays the messages the client missed since its last message, and the edits and unsends of older messages
+// made while it was away. Registration happens first so nothing is lost in between; clients de-duplicate by message ID.
+// When the client's last message can't be found, or it missed too much, it is told to resync instead.
+func (c *Client) resume(ctx context.Context, messageID string) {
+	messages, changed, err := types.ListMessagesSince(ctx, c.hub.db.Db, c.userID, messageID, resumeLimit+1)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logrus.WithError(err).Error("Failed to load missed messages")
+		}
+		c.emit(Event{Type: EventResync})
+		return
+	}
+	if len(messages) > resumeLimit || len(changed) > resumeLimit {
+		c.emit(Event{Type: EventResync})
+		return
+	}
+	all := append(types.MessagePtrs(messages), types.MessagePtrs(changed)...)
+	if err := types.AttachMessageDetails(ctx, c.hub.db.Db, c.userID, all...); err != nil {
+		logrus.WithError(err).Error("Failed to load message attachments")
+	}
+
+	var delivered []string
+	for _, message := range messages {
+		c.emit(Event{Type: EventMessage, ConversationID: message.ConversationID, MessageID: message.MessageID, Data: message})
+		if message.SenderID != c.userID {
+			delivered = append(delivered, message.MessageID)
+		}
+	}
+	for _, message := range changed {
+		kind := EventMessageEdited
+		if message.UnsentAt != nil {
+			kind = EventMessageDeleted
+		}
+		c.emit(Event{Type: kind, ConversationID: message.ConversationID, MessageID: message.MessageID, Data: message})
+	}
+	if len(delivered) > 0 {
+		c.hub.markDelivered(ctx, c.userID, delivered)
+	}
+}
+
+// emit queues an event for this connection only
+func (c *Client) emit(event Event) {
+	frame, err := json.Marshal(event)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode realtime event")
+		return
+	}
+	c.enqueue(frame)
+}
+
+// enqueue queues a frame, closing the connection if the client has fallen too far behind
+func (c *Client) enqueue(frame []byte) {
+	select {
+	case c.send <- frame:
+	case <-c.done:
+	default:
+		c.conn.Close()
+	}
+}
+
+// readPump handles client frames and heartbeats until the connection fails
+func (c *Client) readPump(ctx context.Context) {
+	c.conn.SetReadLimit(maxFrameSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if err := types.Heartbeat(ctx, c.hub.db.Db, c.userID); err != nil {
+			logrus.WithError(err).Error("Failed to record heartbeat")
+		}
+		return nil
+	})
+
+	for {
+		var frame inbound
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				logrus.WithError(err).Warn("Realtime connection closed unexpectedly")
+			}
+			return
+		}
+
+		switch frame.Type {
+		case "ping":
+			c.conn.SetReadDeadline(time.Now().Add(pongWait))
+			if err := types.Heartbeat(ctx, c.hub.db.Db, c.userID); err != nil {
+				logrus.WithError(err).Error("Failed to record heartbeat")
+			}
+			c.emit(Event{Type: EventPong})
+		case "typing":
+			c.typing(ctx, frame)
+		default:
+			c.emit(Event{Type: EventError, Data: "unknown event type"})
+		}
+	}
+}
+
+// typing relays a typing indicator to the other participants of a conversation the client belongs to
+func (c *Client) typing(ctx context.Context, frame inbound) {
+	if membership, err := types.ReadConversation(ctx, c.hub.db.Db, frame.ConversationID, c.userID); err != nil || !membership.Active() {
+		c.emit(Event{Type: EventError, ConversationID: frame.ConversationID, Data: "conversation not found"})
+		return
+	}
+	participants, err := types.ListParticipantIDs(ctx, c.hub.db.Db, frame.ConversationID, c.userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load conversation participants")
+		return
+	}
+
+	var recipients []string
+	for _, id := range participants {
+		if allowed, err := types.CanInteract(ctx, c.hub.db.Db, c.userID, id); err == nil && allowed {
+			recipients = append(recipients, id)
+		}
+	}
+
+	typing := frame.Typing == nil || *frame.Typing
+	event := Event{Type: EventTyping, ConversationID: frame.ConversationID, UserID: c.userID, Data: map[string]bool{"typing": typing}}
+	if err := c.hub.Publish(ctx, recipients, event); err != nil {
+		logrus.WithError(err).Error("Failed to publish typing indicator")
+	}
+}
+
+// writePump writes queued frames and pings until the connection is done
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case frame := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
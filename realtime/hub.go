@@ -0,0 +1,268 @@
+package realtime
+
+import (
+	"Engine/storage"
+	"Engine/types"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// channel is the Postgres NOTIFY channel every server instance listens on
+const channel = "realtime_events"
+
+// recipientsPerNotify keeps each NOTIFY payload well under Postgres' 8000 byte limit
+const recipientsPerNotify = 100
+
+// Event types
+const (
+	EventMessage        = "message"
+	EventMessageEdited  = "message_edited"
+	EventMessageDeleted = "message_deleted"
+	EventTyping         = "typing"
+	EventPresence       = "presence"
+	EventReceipt        = "receipt"
+	EventPong           = "pong"
+	EventResync         = "resync"
+	EventError          = "error"
+)
+
+// Event is a frame sent to clients. Message and edit events are published by ID and the message is loaded by the
+// receiving instance, so that large messages never pass through NOTIFY.
+type Event struct {
+	Type           string      `json:"type"`
+	ConversationID string      `json:"conversation_id,omitempty"`
+	UserID         string      `json:"user_id,omitempty"`
+	MessageID      string      `json:"message_id,omitempty"`
+	Data           interface{} `json:"data,omitempty"`
+}
+
+// envelope is the NOTIFY payload: an event and the users it is for
+type envelope struct {
+	Recipients []string `json:"recipients"`
+	Event      Event    `json:"event"`
+}
+
+// Hub tracks the realtime connections on this server and fans events out to every server through Postgres
+type Hub struct {
+	db      *storage.DB
+	conn    string
+	mu      sync.RWMutex
+	clients map[string]map[*Client]struct{}
+}
+
+// NewHub returns a hub that publishes through db and listens with its own connection to conn
+func NewHub(db *storage.DB, conn string) *Hub {
+	return &Hub{db: db, conn: conn, clients: make(map[string]map[*Client]struct{})}
+}
+
+// Start listens for events published by any server until ctx is cancelled
+func (h *Hub) Start(ctx context.Context) error {
+	listener := pq.NewListener(h.conn, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logrus.WithError(err).Error("Realtime listener connection error")
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		ping := time.NewTicker(90 * time.Second)
+		defer ping.Stop()
+		defer listener.Close()
+		logrus.Infof("Listening for realtime events on %s", channel)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established and events may have been missed;
+				// clients recover them by resuming from their last message ID
+				if n == nil {
+					logrus.Warn("Realtime listener reconnected")
+					continue
+				}
+				h.dispatch(ctx, n.Extra)
+			case <-ping.C:
+				go listener.Ping()
+			}
+		}
+	}()
+	return nil
+}
+
+// Publish sends event to every connected device of recipients on every server
+func (h *Hub) Publish(ctx context.Context, recipients []string, event Event) error {
+	for start := 0; start < len(recipients); start += recipientsPerNotify {
+		end := start + recipientsPerNotify
+		if end > len(recipients) {
+			end = len(recipients)
+		}
+		payload, err := json.Marshal(envelope{Recipients: recipients[start:end], Event: event})
+		if err != nil {
+			return err
+		}
+		if _, err := h.db.Db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch delivers a published event to the recipients connected to this server
+func (h *Hub) dispatch(ctx context.Context, payload string) {
+	var env envelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		logrus.WithError(err).Error("Failed to decode realtime event")
+		return
+	}
+
+	local := make(map[string][]*Client)
+	h.mu.RLock()
+	for _, userID := range env.Recipients {
+		for c := range h.clients[userID] {
+			local[userID] = append(local[userID], c)
+		}
+	}
+	h.mu.RUnlock()
+	if len(local) == 0 {
+		return
+	}
+
+	if (env.Event.Type == EventMessage || env.Event.Type == EventMessageEdited) && env.Event.Data == nil {
+		h.dispatchMessage(ctx, env.Event, local)
+		return
+	}
+
+	frame, err := json.Marshal(env.Event)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to encode realtime event")
+		return
+	}
+	for _, clients := range local {
+		for _, c := range clients {
+			c.enqueue(frame)
+		}
+	}
+}
+
+// dispatchMessage loads a published message with its attachments and delivers it to the local clients of each
+// recipient, recording delivery of new messages. A shared post's preview depends on who may see the post, so those messages are
+// rendered for each recipient separately.
+func (h *Hub) dispatchMessage(ctx context.Context, event Event, local map[string][]*Client) {
+	id, err := uuid.Parse(event.MessageID)
+	if err != nil {
+		return
+	}
+	var message types.Message
+	if err := message.Read(ctx, h.db.Db, id); err != nil {
+		logrus.WithError(err).Error("Failed to load message for realtime delivery")
+		return
+	}
+
+	var shared []byte
+	for userID, clients := range local {
+		frame := shared
+		if frame == nil {
+			viewed := message
+			if err := types.AttachMessageDetails(ctx, h.db.Db, userID, &viewed); err != nil {
+				logrus.WithError(err).Error("Failed to load message attachments")
+				continue
+			}
+			event.Data = viewed
+			if frame, err = json.Marshal(event); err != nil {
+				logrus.WithError(err).Error("Failed to encode realtime event")
+				return
+			}
+			if message.SharedPostID == nil {
+				shared = frame
+			}
+		}
+
+		for _, c := range clients {
+			c.enqueue(frame)
+		}
+		if event.Type == EventMessage && userID != message.SenderID {
+			h.markDelivered(ctx, userID, []string{message.MessageID})
+		}
+	}
+}
+
+// markDelivered records that messages reached userID and tells their senders
+func (h *Hub) markDelivered(ctx context.Context, userID string, messageIDs []string) {
+	updates, err := types.MarkDelivered(ctx, h.db.Db, userID, messageIDs)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to record message delivery")
+		return
+	}
+	if err := h.PublishReceipts(ctx, userID, types.ReceiptDelivered, updates); err != nil {
+		logrus.WithError(err).Error("Failed to publish delivery receipts")
+	}
+}
+
+// PublishReceipts tells the senders of the messages in updates that userID's receipts for them changed to status
+func (h *Hub) PublishReceipts(ctx context.Context, userID, status string, updates []types.ReceiptUpdate) error {
+	type key struct{ sender, conversation string }
+	grouped := make(map[key][]types.ReceiptUpdate)
+	for _, u := range updates {
+		k := key{u.SenderID, u.ConversationID}
+		grouped[k] = append(grouped[k], u)
+	}
+
+	for k, receipts := range grouped {
+		event := Event{
+			Type:           EventReceipt,
+			ConversationID: k.conversation,
+			UserID:         userID,
+			Data:           map[string]interface{}{"status": status, "receipts": receipts},
+		}
+		if err := h.Publish(ctx, []string{k.sender}, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register adds a connection
+func (h *Hub) register(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[c.userID] == nil {
+		h.clients[c.userID] = make(map[*Client]struct{})
+	}
+	h.clients[c.userID][c] = struct{}{}
+}
+
+// unregister removes a connection
+func (h *Hub) unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[c.userID], c)
+	if len(h.clients[c.userID]) == 0 {
+		delete(h.clients, c.userID)
+	}
+}
+
+// publishPresence tells userID's contacts whether they are online
+func (h *Hub) publishPresence(ctx context.Context, userID string) {
+	presence, err := types.ReadPresence(ctx, h.db.Db, userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load presence")
+		return
+	}
+	contacts, err := types.ListContactIDs(ctx, h.db.Db, userID)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to load presence contacts")
+		return
+	}
+	if err := h.Publish(ctx, contacts, Event{Type: EventPresence, UserID: userID, Data: presence}); err != nil {
+		logrus.WithError(err).Error("Failed to publish presence")
+	}
+}
@@ -1,9 +1,11 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 
+	"github.com/lib/pq"
 )
 
 // ObjectToDatabase converts a struct to column names and values.
@@ -34,3 +36,15 @@ func ObjectToDatabase(obj interface{}) ([]string, []interface{}, error) {
 	}
 	return columns, values, nil
 }
+
+// IsForeignKeyViolation reports whether err is a Postgres foreign key constraint violation.
+func IsForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23503"
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -11,11 +11,30 @@ type BlockedUser struct {
 	BlockedUserID string `json:"blocked_user_id" db:"blocked_user_id"`
 }
 
-// Create a new blocked user relationship
+// Create a new blocked user relationship, removing follows, close friend entries and suggestions in either direction
 func (b *BlockedUser) Create(ctx context.Context, db *sqlx.DB) error {
-	query := `INSERT INTO blocked_users (blocker_id, blocked_user_id) VALUES (:blocker_id, :blocked_user_id)`
-	_, err := db.NamedExecContext(ctx, query, b)
-	return err
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO blocked_users (blocker_id, blocked_user_id) VALUES (:blocker_id, :blocked_user_id) ON CONFLICT DO NOTHING`
+	if _, err := tx.NamedExecContext(ctx, query, b); err != nil {
+		return err
+	}
+
+	severed := []string{
+		`DELETE FROM followings WHERE (follower_id = $1 AND following_id = $2) OR (follower_id = $2 AND following_id = $1)`,
+		`DELETE FROM close_friends WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)`,
+		`DELETE FROM follow_suggestions WHERE (user_id = $1 AND candidate_id = $2) OR (user_id = $2 AND candidate_id = $1)`,
+	}
+	for _, query := range severed {
+		if _, err := tx.ExecContext(ctx, query, b.BlockerID, b.BlockedUserID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 // Delete a blocked user relationship
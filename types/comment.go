@@ -7,18 +7,45 @@ import (
 	"github.com/google/uuid"
 )
 
-// Comment represents a comment on a post
+// DeletedCommentContent replaces the content of deleted comments that still have replies
+const DeletedCommentContent = "[deleted]"
+
+// CommentEditWindow is how long after posting a comment its author may edit it
+var CommentEditWindow = EnvDuration("COMMENT_EDIT_WINDOW", 15*time.Minute)
+
+// Comment represents a comment on a post, or a reply to a top-level comment
 type Comment struct {
+	CommentID       string     `json:"comment_id" db:"comment_id"`
+	UserID          string     `json:"user_id,omitempty" db:"user_id"`
+	PostID          string     `json:"post_id" db:"post_id"`
+	ParentCommentID *string    `json:"parent_comment_id,omitempty" db:"parent_comment_id"`
+	Content         string     `json:"content" db:"content"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	EditedAt        *time.Time `json:"edited_at,omitempty" db:"edited_at"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	LikeCount       int        `json:"like_count" db:"like_count"`
+	ReplyCount      int        `json:"reply_count" db:"reply_count"`
+	Pinned          bool       `json:"pinned" db:"pinned"`
+	ViewerLiked     bool       `json:"viewer_liked" db:"viewer_liked"`
+}
+
+// CommentEdit is a prior version of an edited comment
+type CommentEdit struct {
+	EditID    string    `json:"edit_id" db:"edit_id"`
 	CommentID string    `json:"comment_id" db:"comment_id"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	PostID    string    `json:"post_id" db:"post_id"`
 	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	WrittenAt time.Time `json:"written_at" db:"written_at"`
+}
+
+// CommentPage is one page of a comment listing
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
 }
 
 // Create a new comment
 func (c *Comment) Create(ctx context.Context, db *sqlx.DB) error {
-	query := `INSERT INTO comments (comment_id, user_id, post_id, content, created_at) VALUES (:comment_id, :user_id, :post_id, :content, :created_at)`
+	query := `INSERT INTO comments (comment_id, user_id, post_id, parent_comment_id, content, created_at) VALUES (:comment_id, :user_id, :post_id, :parent_comment_id, :content, :created_at)`
 	_, err := db.NamedExecContext(ctx, query, c)
 	return err
 }
@@ -26,27 +53,208 @@ func (c *Comment) Create(ctx context.Context, db *sqlx.DB) error {
 // Read a comment by ID
 func (c *Comment) Read(ctx context.Context, db *sqlx.DB, commentID uuid.UUID) error {
 	query := `SELECT * FROM comments WHERE comment_id = $1`
-	return db.GetContext(ctx, c, query, commentID)
+	if err := db.GetContext(ctx, c, query, commentID); err != nil {
+		return err
+	}
+	c.redact()
+	return nil
+}
+
+// Editable reports whether the comment is still within its edit window
+func (c *Comment) Editable(now time.Time) bool {
+	return c.DeletedAt == nil && now.Sub(c.CreatedAt) <= CommentEditWindow
 }
 
-// Update a comment
+// Update a comment's content, keeping the previous version in the edit history.
+// created_at is left untouched; edited_at records when the change was made.
 func (c *Comment) Update(ctx context.Context, db *sqlx.DB) error {
-	query := `UPDATE comments SET content=:content, created_at=:created_at WHERE comment_id=:comment_id`
-	_, err := db.NamedExecContext(ctx, query, c)
-	return err
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO comment_edits (edit_id, comment_id, content, written_at)
+			  SELECT $1, comment_id, content, COALESCE(edited_at, created_at) FROM comments WHERE comment_id = $2 AND deleted_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, uuid.New().String(), c.CommentID); err != nil {
+		return err
+	}
+
+	query = `UPDATE comments SET content = $1, edited_at = NOW() WHERE comment_id = $2 AND deleted_at IS NULL RETURNING edited_at`
+	if err := tx.GetContext(ctx, &c.EditedAt, query, c.Content, c.CommentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// List the prior versions of a comment, oldest first
+func ListCommentEdits(ctx context.Context, db *sqlx.DB, commentID string) ([]CommentEdit, error) {
+	var edits []CommentEdit
+	query := `SELECT * FROM comment_edits WHERE comment_id = $1 ORDER BY written_at`
+	err := db.SelectContext(ctx, &edits, query, commentID)
+	return edits, err
 }
 
-// Delete a comment by ID
+// Delete a comment by ID along with its edit history, unpinning it if needed. The row is kept as a tombstone so its replies stay threaded.
 func (c *Comment) Delete(ctx context.Context, db *sqlx.DB, commentID uuid.UUID) error {
-	query := `DELETE FROM comments WHERE comment_id = $1`
+	query := `WITH history AS (DELETE FROM comment_edits WHERE comment_id = $1),
+			  unpin AS (UPDATE posts SET pinned_comment_id = NULL WHERE pinned_comment_id = $1)
+			  UPDATE comments SET content = '', deleted_at = NOW() WHERE comment_id = $1 AND deleted_at IS NULL`
 	_, err := db.ExecContext(ctx, query, commentID)
 	return err
 }
 
-// List comments for a post
-func ListComments(ctx context.Context, db *sqlx.DB, postID string) ([]Comment, error) {
+// redact hides the author and content of a deleted comment
+func (c *Comment) redact() {
+	if c.DeletedAt != nil {
+		c.UserID = ""
+		c.Content = DeletedCommentContent
+	}
+}
+
+// Comment listing sort orders
+const (
+	CommentSortNewest = "newest"
+	CommentSortTop    = "top"
+)
+
+// commentColumns selects a comment aliased as c, on a post aliased as p, with its count of live replies,
+// whether it is pinned and whether the viewer bound to the given placeholder liked it
+func commentColumns(viewer string) string {
+	return `c.*,
+		(SELECT COUNT(*) FROM comments r WHERE r.parent_comment_id = c.comment_id AND r.deleted_at IS NULL) AS reply_count,
+		(c.comment_id = p.pinned_comment_id) IS TRUE AS pinned,
+		EXISTS (SELECT 1 FROM comment_likes cl WHERE cl.comment_id = c.comment_id AND cl.user_id = ` + viewer + `) AS viewer_liked`
+}
+
+// List a page of top-level comments on a post the viewer may see, sorted newest first or by likes.
+// Deleted comments are kept only while they still have replies. The pinned comment leads the first page.
+func ListComments(ctx context.Context, db *sqlx.DB, postID, viewerID, sort string, cursor Cursor, limit int) (CommentPage, error) {
 	var comments []Comment
-	query := `SELECT * FROM comments WHERE post_id = $1 ORDER BY created_at DESC`
-	err := db.SelectContext(ctx, &comments, query, postID)
-	return comments, err
+	base := `SELECT ` + commentColumns("$2") + ` FROM comments c JOIN posts p ON p.post_id = c.post_id
+			 WHERE c.post_id = $1 AND c.parent_comment_id IS NULL AND ` + VisibleTo("$2") + ` AND ` + NotBlocked("c.user_id", "$2") + `
+			 AND (c.deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments r WHERE r.parent_comment_id = c.comment_id AND r.deleted_at IS NULL))`
+
+	if cursor.First {
+		var pinned []Comment
+		query := base + ` AND c.comment_id = p.pinned_comment_id AND c.deleted_at IS NULL`
+		if err := db.SelectContext(ctx, &pinned, query, postID, viewerID); err != nil {
+			return CommentPage{}, err
+		}
+		comments = append(comments, pinned...)
+	}
+
+	var page []Comment
+	query := base + ` AND c.comment_id IS DISTINCT FROM p.pinned_comment_id
+			 AND (c.created_at, c.comment_id) < ($3, $4)
+			 ORDER BY c.created_at DESC, c.comment_id DESC LIMIT $5`
+	args := []interface{}{postID, viewerID, cursor.Time, cursor.ID, limit + 1}
+	if sort == CommentSortTop {
+		query = base + ` AND c.comment_id IS DISTINCT FROM p.pinned_comment_id
+			 AND (c.like_count, c.created_at, c.comment_id) < ($3, $4, $5)
+			 ORDER BY c.like_count DESC, c.created_at DESC, c.comment_id DESC LIMIT $6`
+		args = []interface{}{postID, viewerID, cursor.Score, cursor.Time, cursor.ID, limit + 1}
+	}
+	if err := db.SelectContext(ctx, &page, query, args...); err != nil {
+		return CommentPage{}, err
+	}
+
+	result := newCommentPage(page, limit)
+	result.Comments = append(comments, result.Comments...)
+	return result, nil
+}
+
+// List a page of replies to a comment on a post the viewer may see, oldest first
+func ListReplies(ctx context.Context, db *sqlx.DB, parentID, viewerID string, cursor Cursor, limit int) (CommentPage, error) {
+	var comments []Comment
+	query := `SELECT ` + commentColumns("$2") + ` FROM comments c JOIN posts p ON p.post_id = c.post_id
+			  WHERE c.parent_comment_id = $1 AND c.deleted_at IS NULL AND ` + VisibleTo("$2") + ` AND ` + NotBlocked("c.user_id", "$2") + `
+			  AND (c.created_at, c.comment_id) > ($3, $4)
+			  ORDER BY c.created_at, c.comment_id LIMIT $5`
+	if err := db.SelectContext(ctx, &comments, query, parentID, viewerID, cursor.Time, cursor.ID, limit+1); err != nil {
+		return CommentPage{}, err
+	}
+	return newCommentPage(comments, limit), nil
+}
+
+// newCommentPage trims a limit+1 result to limit and sets the cursor when there is more
+func newCommentPage(comments []Comment, limit int) CommentPage {
+	page := CommentPage{Comments: comments}
+	if len(comments) > limit {
+		page.Comments = comments[:limit]
+		last := page.Comments[limit-1]
+		page.NextCursor = Cursor{Score: last.LikeCount, Time: last.CreatedAt, ID: last.CommentID}.Encode()
+	}
+	if page.Comments == nil {
+		page.Comments = []Comment{}
+	}
+	for i := range page.Comments {
+		page.Comments[i].redact()
+	}
+	return page
+}
+
+// CommentLike represents a like on a comment
+type CommentLike struct {
+	CommentID string    `json:"comment_id" db:"comment_id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Create a comment like and bump the comment's like count. Liking twice is a no-op and reports false.
+func (l *CommentLike) Create(ctx context.Context, db *sqlx.DB) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO comment_likes (comment_id, user_id, created_at) VALUES (:comment_id, :user_id, :created_at) ON CONFLICT (comment_id, user_id) DO NOTHING`
+	res, err := tx.NamedExecContext(ctx, query, l)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE comments SET like_count = like_count + 1 WHERE comment_id = $1`, l.CommentID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Delete a comment like and decrement the comment's like count. Reports false if it didn't exist.
+func (l *CommentLike) Delete(ctx context.Context, db *sqlx.DB) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM comment_likes WHERE comment_id = $1 AND user_id = $2`, l.CommentID, l.UserID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE comments SET like_count = GREATEST(like_count - 1, 0) WHERE comment_id = $1`, l.CommentID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PinComment pins a top-level comment to a user's post, or unpins when commentID is nil.
+// Reports false if the post doesn't belong to the user or the comment isn't a live top-level comment on it.
+func PinComment(ctx context.Context, db *sqlx.DB, postID, userID string, commentID *string) (bool, error) {
+	query := `UPDATE posts SET pinned_comment_id = $1 WHERE post_id = $2 AND user_id = $3
+			  AND ($1::uuid IS NULL OR EXISTS (SELECT 1 FROM comments c WHERE c.comment_id = $1 AND c.post_id = $2 AND c.parent_comment_id IS NULL AND c.deleted_at IS NULL))`
+	res, err := db.ExecContext(ctx, query, commentID, postID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
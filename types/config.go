@@ -0,0 +1,64 @@
+package types
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvFloat reads a float64 from the environment, falling back to def when unset or invalid.
+func EnvFloat(key string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+// EnvPositiveFloat reads a float64 that must be greater than zero, such as a divisor, falling back to def when unset,
+// invalid or not positive.
+func EnvPositiveFloat(key string, def float64) float64 {
+	if v := EnvFloat(key, def); v > 0 {
+		return v
+	}
+	return def
+}
+
+// EnvInt reads an int from the environment, falling back to def when unset or invalid.
+func EnvInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// EnvDuration reads a time.Duration (e.g. "24h") from the environment, falling back to def when unset or invalid.
+func EnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// EnvPositiveDuration reads a time.Duration that must be greater than zero, falling back to def when unset, invalid or not positive.
+func EnvPositiveDuration(key string, def time.Duration) time.Duration {
+	if v := EnvDuration(key, def); v > 0 {
+		return v
+	}
+	return def
+}
+
+// EnvList reads a comma-separated list from the environment, falling back to def when unset.
+func EnvList(key string, def []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,337 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Conversation kinds
+const (
+	ConversationDirect = "direct"
+	ConversationGroup  = "group"
+)
+
+// Participant roles
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+// Conversation is a thread of messages between its participants
+type Conversation struct {
+	ConversationID string    `json:"conversation_id" db:"conversation_id"`
+	Kind           string    `json:"kind" db:"kind"`
+	DirectKey      *string   `json:"-" db:"direct_key"`
+	Name           *string   `json:"name,omitempty" db:"name"`
+	AvatarURL      *string   `json:"avatar_url,omitempty" db:"avatar_url"`
+	CreatedBy      *string   `json:"created_by,omitempty" db:"created_by"`
+	RequestState   string    `json:"request_state" db:"request_state"`
+	RequestedBy    *string   `json:"requested_by,omitempty" db:"requested_by"`
+	MessageTTL     *int      `json:"message_ttl,omitempty" db:"message_ttl"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+	LastMessageAt  time.Time `json:"last_message_at" db:"last_message_at"`
+}
+
+// Participant is a user's membership of a conversation. Members who leave keep their row with left_at set
+// so they can still read what was said while they were in it.
+type Participant struct {
+	ConversationID string     `json:"conversation_id" db:"conversation_id"`
+	UserID         string     `json:"user_id" db:"user_id"`
+	Role           string     `json:"role" db:"role"`
+	JoinedAt       time.Time  `json:"joined_at" db:"joined_at"`
+	LeftAt         *time.Time `json:"left_at,omitempty" db:"left_at"`
+	LastReadAt     time.Time  `json:"last_read_at" db:"last_read_at"`
+}
+
+// Membership is a conversation as seen by one of its current or former participants
+type Membership struct {
+	Conversation
+	Role     string     `json:"role" db:"role"`
+	JoinedAt time.Time  `json:"joined_at" db:"joined_at"`
+	LeftAt   *time.Time `json:"left_at,omitempty" db:"left_at"`
+}
+
+// Active reports whether the user is still in the conversation
+func (m *Membership) Active() bool {
+	return m.LeftAt == nil
+}
+
+// IsAdmin reports whether the user currently administers the conversation
+func (m *Membership) IsAdmin() bool {
+	return m.Active() && m.Role == RoleAdmin
+}
+
+// memberCanSee returns the condition that the participant row aliased cp covers the message aliased m:
+// it was sent after they joined and, if they left, no later than when they left. Disappearing messages
+// are hidden as soon as they expire, before the sweep removes them.
+func memberCanSee(cp, m string) string {
+	return `(` + m + `.timestamp >= ` + cp + `.joined_at AND (` + cp + `.left_at IS NULL OR ` + m + `.timestamp <= ` + cp + `.left_at)
+		AND (` + m + `.expires_at IS NULL OR ` + m + `.expires_at > NOW()))`
+}
+
+// InboxEntry is a conversation in a user's inbox with its latest message and the user's unread count
+type InboxEntry struct {
+	Membership
+	UnreadCount  int           `json:"unread_count" db:"unread_count"`
+	Participants []UserSummary `json:"participants" db:"-"`
+	LastMessage  *Message      `json:"last_message,omitempty" db:"-"`
+}
+
+// InboxPage is one page of a user's inbox
+type InboxPage struct {
+	Conversations []InboxEntry `json:"conversations"`
+	NextCursor    string       `json:"next_cursor,omitempty"`
+}
+
+// MessagePage is one page of a conversation's history
+type MessagePage struct {
+	Messages   []Message `json:"messages"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// directKey identifies the direct conversation between two users regardless of who started it
+func directKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
+
+// OpenDirectConversation returns the direct conversation between two users, creating it on first contact
+func OpenDirectConversation(ctx context.Context, db *sqlx.DB, userID, otherID string) (Conversation, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Conversation{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var conv Conversation
+	query := `INSERT INTO conversations (conversation_id, kind, direct_key, created_at, last_message_at) VALUES ($1, $2, $3, $4, $4)
+			  ON CONFLICT (direct_key) DO UPDATE SET direct_key = EXCLUDED.direct_key
+			  RETURNING *`
+	if err := tx.GetContext(ctx, &conv, query, uuid.New().String(), ConversationDirect, directKey(userID, otherID), now); err != nil {
+		return Conversation{}, err
+	}
+
+	query = `INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at, last_read_at) VALUES ($1, $2, $5, $3, $3), ($1, $4, $5, $3, $3)
+			 ON CONFLICT (conversation_id, user_id) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, conv.ConversationID, userID, now, otherID, RoleMember); err != nil {
+		return Conversation{}, err
+	}
+
+	return conv, tx.Commit()
+}
+
+// ReadConversation loads a conversation that userID participates or once participated in, returning sql.ErrNoRows otherwise
+func ReadConversation(ctx context.Context, db *sqlx.DB, conversationID, userID string) (Membership, error) {
+	var membership Membership
+	query := `SELECT c.*, cp.role, cp.joined_at, cp.left_at FROM conversations c
+			  JOIN conversation_participants cp ON cp.conversation_id = c.conversation_id AND cp.user_id = $2
+			  WHERE c.conversation_id = $1`
+	err := db.GetContext(ctx, &membership, query, conversationID, userID)
+	return membership, err
+}
+
+// ListMemberIDs returns the IDs of all of a conversation's current participants
+func ListMemberIDs(ctx context.Context, db *sqlx.DB, conversationID string) ([]string, error) {
+	var ids []string
+	query := `SELECT user_id FROM conversation_participants WHERE conversation_id = $1 AND left_at IS NULL`
+	err := db.SelectContext(ctx, &ids, query, conversationID)
+	return ids, err
+}
+
+// ListParticipantIDs returns the IDs of a conversation's current participants other than userID
+func ListParticipantIDs(ctx context.Context, db *sqlx.DB, conversationID, userID string) ([]string, error) {
+	var ids []string
+	query := `SELECT user_id FROM conversation_participants WHERE conversation_id = $1 AND user_id <> $2 AND left_at IS NULL`
+	err := db.SelectContext(ctx, &ids, query, conversationID, userID)
+	return ids, err
+}
+
+// Send stores a message in its conversation with a receipt for each recipient, bumps the conversation in its participants' inboxes and marks it read for the sender
+func (m *Message) Send(ctx context.Context, db *sqlx.DB) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := admitMessage(ctx, tx, m); err != nil {
+		return err
+	}
+	if err := insertMessage(ctx, tx, m); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertMessage stores a message and its receipts and updates its conversation's activity within tx.
+// Messages other than system notices expire if the conversation has a disappearing message timer.
+func insertMessage(ctx context.Context, tx *sqlx.Tx, m *Message) error {
+	var ttl *int
+	if err := tx.GetContext(ctx, &ttl, `UPDATE conversations SET last_message_at = $2 WHERE conversation_id = $1 RETURNING message_ttl`, m.ConversationID, m.Timestamp); err != nil {
+		return err
+	}
+	m.ExpiresAt = nil
+	if ttl != nil && m.ContentType != MessageTypeSystem {
+		expiresAt := m.Timestamp.Add(time.Duration(*ttl) * time.Second)
+		m.ExpiresAt = &expiresAt
+	}
+
+	query := `INSERT INTO messages (message_id, conversation_id, sender_id, receiver_id, content_type, content, media_url, media_id, shared_post_id,
+				latitude, longitude, location_name, timestamp, is_read, expires_at)
+			  VALUES (:message_id, :conversation_id, :sender_id, :receiver_id, :content_type, :content, :media_url, :media_id, :shared_post_id,
+				:latitude, :longitude, :location_name, :timestamp, :is_read, :expires_at)`
+	if _, err := tx.NamedExecContext(ctx, query, m); err != nil {
+		return err
+	}
+	if err := createReceipts(ctx, tx, m); err != nil {
+		return err
+	}
+	query = `UPDATE conversation_participants SET last_read_at = GREATEST(last_read_at, $3) WHERE conversation_id = $1 AND user_id = $2`
+	_, err := tx.ExecContext(ctx, query, m.ConversationID, m.SenderID, m.Timestamp)
+	return err
+}
+
+// ListInbox returns a page of a user's conversations that have messages they can see, most recently active first.
+// Message requests sent to the user are left out, as are direct conversations with a user in a block with them.
+func ListInbox(ctx context.Context, db *sqlx.DB, userID string, cursor Cursor, limit int) (InboxPage, error) {
+	return listConversations(ctx, db, userID, `(c.request_state = 'accepted' OR c.requested_by = $1)`, cursor, limit)
+}
+
+// listConversations returns a page of a user's conversations that match the SQL condition folder
+func listConversations(ctx context.Context, db *sqlx.DB, userID, folder string, cursor Cursor, limit int) (InboxPage, error) {
+	var entries []InboxEntry
+	query := `SELECT c.*, cp.role, cp.joined_at, cp.left_at,
+				(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.conversation_id AND m.sender_id <> $1
+					AND m.timestamp > cp.last_read_at AND ` + memberCanSee("cp", "m") + ` AND ` + notHidden("m", "$1") + `) AS unread_count
+			  FROM conversation_participants cp
+			  JOIN conversations c ON c.conversation_id = cp.conversation_id
+			  WHERE cp.user_id = $1
+				AND EXISTS (SELECT 1 FROM messages m WHERE m.conversation_id = c.conversation_id AND ` + memberCanSee("cp", "m") + `)
+				AND (c.last_message_at, c.conversation_id) < ($2, $3)
+				AND ` + folder + `
+				AND (c.kind <> 'direct' OR NOT EXISTS (SELECT 1 FROM conversation_participants o
+					WHERE o.conversation_id = c.conversation_id AND o.user_id <> $1 AND NOT ` + NotBlocked("o.user_id", "$1") + `))
+			  ORDER BY c.last_message_at DESC, c.conversation_id DESC LIMIT $4`
+	if err := db.SelectContext(ctx, &entries, query, userID, cursor.Time, cursor.ID, limit+1); err != nil {
+		return InboxPage{}, err
+	}
+
+	page := InboxPage{Conversations: entries}
+	if len(entries) > limit {
+		page.Conversations = entries[:limit]
+		last := page.Conversations[limit-1]
+		page.NextCursor = Cursor{Time: last.LastMessageAt, ID: last.ConversationID}.Encode()
+	}
+	if page.Conversations == nil {
+		page.Conversations = []InboxEntry{}
+	}
+	return page, attachInboxDetails(ctx, db, userID, page.Conversations)
+}
+
+// attachInboxDetails loads the other current participants and latest visible message of each inbox entry
+func attachInboxDetails(ctx context.Context, db *sqlx.DB, userID string, entries []InboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	ids := make([]string, len(entries))
+	byID := make(map[string]*InboxEntry, len(entries))
+	for i := range entries {
+		ids[i] = entries[i].ConversationID
+		byID[ids[i]] = &entries[i]
+		entries[i].Participants = []UserSummary{}
+	}
+
+	var participants []struct {
+		ConversationID string `db:"conversation_id"`
+		UserSummary
+	}
+	query := `SELECT cp.conversation_id, ` + userSummaryColumns + ` FROM conversation_participants cp
+			  JOIN users u ON u.user_id = cp.user_id
+			  WHERE cp.conversation_id = ANY($1) AND cp.user_id <> $2 AND cp.left_at IS NULL
+			  ORDER BY cp.joined_at`
+	if err := db.SelectContext(ctx, &participants, query, pq.Array(ids), userID); err != nil {
+		return err
+	}
+	for _, p := range participants {
+		byID[p.ConversationID].Participants = append(byID[p.ConversationID].Participants, p.UserSummary)
+	}
+
+	var messages []Message
+	query = `SELECT DISTINCT ON (m.conversation_id) m.* FROM messages m
+			 JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $2
+			 WHERE m.conversation_id = ANY($1) AND ` + memberCanSee("cp", "m") + ` AND ` + notHidden("m", "$2") + `
+			 ORDER BY m.conversation_id, m.timestamp DESC, m.message_id DESC`
+	if err := db.SelectContext(ctx, &messages, query, pq.Array(ids), userID); err != nil {
+		return err
+	}
+	for i := range messages {
+		byID[messages[i].ConversationID].LastMessage = &messages[i]
+	}
+	return AttachMessageDetails(ctx, db, userID, MessagePtrs(messages)...)
+}
+
+// ListConversationMessages returns a page of the messages in a conversation that userID can see and hasn't deleted, newest first
+func ListConversationMessages(ctx context.Context, db *sqlx.DB, conversationID, userID string, cursor Cursor, limit int) (MessagePage, error) {
+	var messages []Message
+	query := `SELECT m.* FROM messages m
+			  JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $5
+			  WHERE m.conversation_id = $1 AND (m.timestamp, m.message_id) < ($2, $3) AND ` + memberCanSee("cp", "m") + `
+				AND ` + notHidden("m", "$5") + `
+			  ORDER BY m.timestamp DESC, m.message_id DESC LIMIT $4`
+	if err := db.SelectContext(ctx, &messages, query, conversationID, cursor.Time, cursor.ID, limit+1, userID); err != nil {
+		return MessagePage{}, err
+	}
+
+	page := MessagePage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		last := page.Messages[limit-1]
+		page.NextCursor = Cursor{Time: last.Timestamp, ID: last.MessageID}.Encode()
+	}
+	if page.Messages == nil {
+		page.Messages = []Message{}
+	}
+	return page, nil
+}
+
+// ListMessagesSince returns up to limit messages from any of userID's conversations sent after messageID, oldest first,
+// and up to limit older messages that were edited or unsent since then, so a reconnecting client can catch up on what
+// it missed. It returns sql.ErrNoRows when messageID isn't a message userID can see, such as one that has since disappeared.
+func ListMessagesSince(ctx context.Context, db *sqlx.DB, userID, messageID string, limit int) (messages, changed []Message, err error) {
+	var since struct {
+		Timestamp time.Time `db:"timestamp"`
+		MessageID string    `db:"message_id"`
+	}
+	query := `SELECT m.timestamp, m.message_id FROM messages m
+			  JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+			  WHERE m.message_id = $2 AND ` + memberCanSee("cp", "m") + ` AND ` + notHidden("m", "$1")
+	if err := db.GetContext(ctx, &since, query, userID, messageID); err != nil {
+		return nil, nil, err
+	}
+
+	query = `SELECT m.* FROM messages m
+			 JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+			 WHERE (m.timestamp, m.message_id) > ($2, $3) AND ` + memberCanSee("cp", "m") + `
+			   AND ` + notHidden("m", "$1") + `
+			 ORDER BY m.timestamp, m.message_id LIMIT $4`
+	if err := db.SelectContext(ctx, &messages, query, userID, since.Timestamp, since.MessageID, limit); err != nil {
+		return nil, nil, err
+	}
+
+	query = `SELECT m.* FROM messages m
+			 JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+			 WHERE (m.timestamp, m.message_id) <= ($2, $3) AND (m.edited_at > $2 OR m.unsent_at > $2)
+			   AND ` + memberCanSee("cp", "m") + ` AND ` + notHidden("m", "$1") + `
+			 ORDER BY COALESCE(m.unsent_at, m.edited_at), m.message_id LIMIT $4`
+	if err := db.SelectContext(ctx, &changed, query, userID, since.Timestamp, since.MessageID, limit); err != nil {
+		return nil, nil, err
+	}
+	return messages, changed, nil
+}
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"encoding/base64"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor is a keyset pagination position: the score, timestamp and ID of the last item on the previous page.
+// Score is only used by listings sorted by a count, such as top comments.
+type Cursor struct {
+	Score int
+	Time  time.Time
+	ID    string
+	First bool // the cursor is the start of the listing
+}
+
+var (
+	cursorEnd   = Cursor{Score: math.MaxInt32, Time: time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC), ID: "ffffffff-ffff-ffff-ffff-ffffffffffff", First: true}
+	cursorStart = Cursor{Score: math.MinInt32, Time: time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC), ID: "00000000-0000-0000-0000-000000000000", First: true}
+)
+
+// Encode returns the opaque string form of the cursor handed to clients
+func (c Cursor) Encode() string {
+	raw := strconv.Itoa(c.Score) + "|" + c.Time.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a client cursor. An empty string gives the first page for a newest-first (desc) or oldest-first listing.
+func DecodeCursor(s string, desc bool) (Cursor, error) {
+	if s == "" {
+		if desc {
+			return cursorEnd, nil
+		}
+		return cursorStart, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 3)
+	if len(parts) != 3 {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	score, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	if _, err := uuid.Parse(parts[2]); err != nil {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	return Cursor{Score: score, Time: t, ID: parts[2]}, nil
+}
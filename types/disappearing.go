@@ -0,0 +1,110 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Limits of a conversation's disappearing message timer
+const (
+	MinMessageTTL = time.Minute
+	MaxMessageTTL = 90 * 24 * time.Hour
+)
+
+// ExpiredMessage is a disappearing message removed by the sweep
+type ExpiredMessage struct {
+	MessageID      string `db:"message_id"`
+	ConversationID string `db:"conversation_id"`
+}
+
+// ValidateMessageTTL checks a disappearing message timer. Zero turns the timer off.
+func ValidateMessageTTL(ttl time.Duration) error {
+	if ttl != 0 && (ttl < MinMessageTTL || ttl > MaxMessageTTL) {
+		return errors.New("timer must be between " + MinMessageTTL.String() + " and " + MaxMessageTTL.String())
+	}
+	return nil
+}
+
+// SetMessageTTL changes how long new messages in a conversation last before they disappear for everyone, or turns
+// the timer off when ttl is zero, with a system message recording the change. Messages already sent keep their expiry.
+func SetMessageTTL(ctx context.Context, db *sqlx.DB, conversationID, actorID string, ttl time.Duration) (Conversation, []Message, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	defer tx.Rollback()
+
+	var seconds *int
+	if ttl > 0 {
+		s := int(ttl.Seconds())
+		seconds = &s
+	}
+
+	var previous *int
+	if err := tx.GetContext(ctx, &previous, `SELECT message_ttl FROM conversations WHERE conversation_id = $1 FOR UPDATE`, conversationID); err != nil {
+		return Conversation{}, nil, err
+	}
+
+	var conv Conversation
+	query := `UPDATE conversations SET message_ttl = $2 WHERE conversation_id = $1 RETURNING *`
+	if err := tx.GetContext(ctx, &conv, query, conversationID, seconds); err != nil {
+		return Conversation{}, nil, err
+	}
+	if (previous == nil) == (seconds == nil) && (previous == nil || *previous == *seconds) {
+		return conv, nil, tx.Commit()
+	}
+
+	notice := SystemNotice{Action: NoticeTimerChanged}
+	if seconds != nil {
+		notice.Value = strconv.Itoa(*seconds)
+	}
+	m, err := insertSystemMessage(ctx, tx, conversationID, actorID, notice, time.Now())
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+
+	return conv, []Message{m}, tx.Commit()
+}
+
+// DeleteExpiredMessages removes up to limit disappearing messages whose time has passed, along with media no other
+// message uses. Receipts and delete-for-me records go with them.
+func DeleteExpiredMessages(ctx context.Context, db *sqlx.DB, limit int) ([]ExpiredMessage, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted []struct {
+		ExpiredMessage
+		MediaID *string `db:"media_id"`
+	}
+	query := `DELETE FROM messages WHERE message_id IN (
+				SELECT message_id FROM messages WHERE expires_at <= NOW() ORDER BY expires_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+			  RETURNING message_id, conversation_id, media_id`
+	if err := tx.SelectContext(ctx, &deleted, query, limit); err != nil {
+		return nil, err
+	}
+
+	expired := make([]ExpiredMessage, len(deleted))
+	var mediaIDs []string
+	for i, d := range deleted {
+		expired[i] = d.ExpiredMessage
+		if d.MediaID != nil {
+			mediaIDs = append(mediaIDs, *d.MediaID)
+		}
+	}
+	if len(mediaIDs) > 0 {
+		query = `DELETE FROM media md WHERE md.media_id = ANY($1) AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.media_id = md.media_id)`
+		if _, err := tx.ExecContext(ctx, query, pq.Array(mediaIDs)); err != nil {
+			return nil, err
+		}
+	}
+
+	return expired, tx.Commit()
+}
@@ -0,0 +1,27 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateMessageTTL(t *testing.T) {
+	tests := []struct {
+		ttl   time.Duration
+		valid bool
+	}{
+		{0, true},
+		{MinMessageTTL, true},
+		{24 * time.Hour, true},
+		{MaxMessageTTL, true},
+		{MinMessageTTL - time.Second, false},
+		{MaxMessageTTL + time.Second, false},
+		{time.Nanosecond, false},
+		{-time.Hour, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateMessageTTL(tt.ttl); (err == nil) != tt.valid {
+			t.Errorf("ValidateMessageTTL(%v) = %v, want valid %v", tt.ttl, err, tt.valid)
+		}
+	}
+}
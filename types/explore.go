@@ -0,0 +1,149 @@
+package types
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ExploreWeights controls how each signal contributes to a post's explore score
+type ExploreWeights struct {
+	Proximity        float64       // weight of closeness to the viewer
+	Likes            float64       // weight of like velocity
+	Comments         float64       // weight of comment velocity
+	Rank             float64       // weight of the author's rank
+	Freshness        float64       // weight of post age decay
+	ProximityScaleKm float64       // distance at which the proximity signal halves
+	FreshnessHalfAge time.Duration // age at which the freshness signal halves
+	VelocityWindow   time.Duration // window over which likes and comments are counted
+	MaxAge           time.Duration // posts older than this are never considered
+	CandidateLimit   int           // number of recent posts scored per request
+}
+
+// ExploreWeightsFromEnv loads explore weights from the environment, using sensible defaults. The scales divide
+// distances and ages, so values that aren't positive fall back to the defaults.
+func ExploreWeightsFromEnv() ExploreWeights {
+	return ExploreWeights{
+		Proximity:        EnvFloat("EXPLORE_WEIGHT_PROXIMITY", 3.0),
+		Likes:            EnvFloat("EXPLORE_WEIGHT_LIKES", 1.0),
+		Comments:         EnvFloat("EXPLORE_WEIGHT_COMMENTS", 1.5),
+		Rank:             EnvFloat("EXPLORE_WEIGHT_RANK", 0.5),
+		Freshness:        EnvFloat("EXPLORE_WEIGHT_FRESHNESS", 2.0),
+		ProximityScaleKm: EnvPositiveFloat("EXPLORE_PROXIMITY_SCALE_KM", 10),
+		FreshnessHalfAge: EnvPositiveDuration("EXPLORE_FRESHNESS_HALF_AGE", 12*time.Hour),
+		VelocityWindow:   EnvDuration("EXPLORE_VELOCITY_WINDOW", 6*time.Hour),
+		MaxAge:           EnvDuration("EXPLORE_MAX_AGE", 7*24*time.Hour),
+		CandidateLimit:   EnvInt("EXPLORE_CANDIDATE_LIMIT", 500),
+	}
+}
+
+// ScoreBreakdown is the weighted contribution of each signal to an explore score
+type ScoreBreakdown struct {
+	Proximity float64 `json:"proximity"`
+	Likes     float64 `json:"likes"`
+	Comments  float64 `json:"comments"`
+	Rank      float64 `json:"rank"`
+	Freshness float64 `json:"freshness"`
+	Total     float64 `json:"total"`
+}
+
+// ExploreCandidate is a post along with the signals used to rank it
+type ExploreCandidate struct {
+	Post
+	AuthorRank     int `json:"-" db:"author_rank"`
+	RecentLikes    int `json:"-" db:"recent_likes"`
+	RecentComments int `json:"-" db:"recent_comments"`
+}
+
+// ExploreItem is a ranked post in the explore feed
+type ExploreItem struct {
+	Post
+	Score *ScoreBreakdown `json:"score,omitempty"`
+}
+
+// Score computes the explore score of a candidate for a viewer at the given location
+func (w ExploreWeights) Score(c ExploreCandidate, viewerLat, viewerLon float64, now time.Time) ScoreBreakdown {
+	var s ScoreBreakdown
+
+	distance := DistanceKm(viewerLat, viewerLon, c.Latitude, c.Longitude)
+	s.Proximity = w.Proximity / (1 + distance/w.ProximityScaleKm)
+
+	// Velocity is expressed per hour so the weights don't depend on the window size
+	hours := math.Max(w.VelocityWindow.Hours(), 1)
+	s.Likes = w.Likes * math.Log1p(float64(c.RecentLikes)/hours)
+	s.Comments = w.Comments * math.Log1p(float64(c.RecentComments)/hours)
+	s.Rank = w.Rank * math.Log1p(math.Max(float64(c.AuthorRank), 0))
+
+	age := now.Sub(c.CreatedAt)
+	if age < 0 {
+		age = 0
+	}
+	s.Freshness = w.Freshness * math.Exp2(-age.Hours()/w.FreshnessHalfAge.Hours())
+
+	s.Total = s.Proximity + s.Likes + s.Comments + s.Rank + s.Freshness
+	return s
+}
+
+// ListExploreCandidates returns recent posts the viewer hasn't authored or already seen
+func ListExploreCandidates(ctx context.Context, db *sqlx.DB, viewerID string, w ExploreWeights) ([]ExploreCandidate, error) {
+	var candidates []ExploreCandidate
+	query := `SELECT p.*, u.rank AS author_rank,
+			(SELECT COUNT(*) FROM likes l WHERE l.post_id = p.post_id AND l.created_at > NOW() - make_interval(secs => $2)) AS recent_likes,
+			(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.post_id AND c.created_at > NOW() - make_interval(secs => $2)) AS recent_comments
+		FROM posts p
+		JOIN users u ON u.user_id = p.user_id
+		WHERE p.user_id <> $1
+		  AND p.original_post_id IS NULL
+		  AND p.created_at > NOW() - make_interval(secs => $3)
+		  AND NOT EXISTS (SELECT 1 FROM post_views v WHERE v.user_id = $1 AND v.post_id = p.post_id)
+		  AND ` + VisibleTo("$1") + `
+		  AND ` + NotMutedPost("$1") + `
+		ORDER BY p.created_at DESC
+		LIMIT $4`
+	err := db.SelectContext(ctx, &candidates, query, viewerID, w.VelocityWindow.Seconds(), w.MaxAge.Seconds(), w.CandidateLimit)
+	return candidates, err
+}
+
+// RankExplore scores candidates, keeps each author's best post and returns the top limit items
+func RankExplore(candidates []ExploreCandidate, viewer *User, w ExploreWeights, limit int, debug bool) []ExploreItem {
+	now := time.Now()
+	type scored struct {
+		candidate ExploreCandidate
+		score     ScoreBreakdown
+	}
+
+	best := make(map[string]scored)
+	for _, c := range candidates {
+		s := w.Score(c, viewer.Latitude, viewer.Longitude, now)
+		if prev, ok := best[c.UserID]; !ok || s.Total > prev.score.Total {
+			best[c.UserID] = scored{candidate: c, score: s}
+		}
+	}
+
+	ranked := make([]scored, 0, len(best))
+	for _, s := range best {
+		ranked = append(ranked, s)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score.Total == ranked[j].score.Total {
+			return ranked[i].candidate.CreatedAt.After(ranked[j].candidate.CreatedAt)
+		}
+		return ranked[i].score.Total > ranked[j].score.Total
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	items := make([]ExploreItem, len(ranked))
+	for i, s := range ranked {
+		items[i] = ExploreItem{Post: s.candidate.Post}
+		if debug {
+			score := s.score
+			items[i].Score = &score
+		}
+	}
+	return items
+}
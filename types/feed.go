@@ -0,0 +1,26 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// FeedItem is a post delivered to a follower's home feed
+type FeedItem struct {
+	UserID    string    `json:"user_id" db:"user_id"`
+	PostID    string    `json:"post_id" db:"post_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// List the posts in a user's home feed that they may see and haven't muted
+func ListFeed(ctx context.Context, db *sqlx.DB, userID string, limit, offset int) ([]Post, error) {
+	var posts []Post
+	query := `SELECT p.* FROM feed_items f
+			  JOIN posts p ON p.post_id = f.post_id
+			  WHERE f.user_id = $1 AND ` + VisibleTo("$1") + ` AND ` + NotMutedPost("$1") + `
+			  ORDER BY f.created_at DESC LIMIT $2 OFFSET $3`
+	err := db.SelectContext(ctx, &posts, query, userID, limit, offset)
+	return posts, err
+}
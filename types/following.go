@@ -2,20 +2,38 @@ package types
 
 import (
     "context"
+    "time"
     "github.com/jmoiron/sqlx"
 )
 
 // Following represents a following relationship between users
 type Following struct {
-    FollowerID  string `json:"follower_id" db:"follower_id"`
-    FollowingID string `json:"following_id" db:"following_id"`
+    FollowerID  string    `json:"follower_id" db:"follower_id"`
+    FollowingID string    `json:"following_id" db:"following_id"`
+    CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
-// Create a new following relationship
-func (f *Following) Create(ctx context.Context, db *sqlx.DB) error {
-    query := `INSERT INTO followings (follower_id, following_id) VALUES (:follower_id, :following_id)`
-    _, err := db.NamedExecContext(ctx, query, f)
-    return err
+// FollowEntry is a user in a follower or following list
+type FollowEntry struct {
+    UserSummary
+    FollowedAt time.Time `json:"followed_at" db:"followed_at"`
+}
+
+// FollowPage is one page of a follower or following list
+type FollowPage struct {
+    Users      []FollowEntry `json:"users"`
+    NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// Create a new following relationship. Following someone twice is a no-op and reports false.
+func (f *Following) Create(ctx context.Context, db *sqlx.DB) (bool, error) {
+    query := `INSERT INTO followings (follower_id, following_id, created_at) VALUES (:follower_id, :following_id, :created_at) ON CONFLICT (follower_id, following_id) DO NOTHING`
+    res, err := db.NamedExecContext(ctx, query, f)
+    if err != nil {
+        return false, err
+    }
+    n, err := res.RowsAffected()
+    return n > 0, err
 }
 
 // Read a following relationship by follower and following IDs
@@ -31,18 +49,39 @@ func (f *Following) Delete(ctx context.Context, db *sqlx.DB, followerID, followi
     return err
 }
 
-// List followers for a user
-func ListFollowers(ctx context.Context, db *sqlx.DB, userID string) ([]Following, error) {
-    var followers []Following
-    query := `SELECT * FROM followings WHERE following_id = $1 ORDER BY follower_id`
-    err := db.SelectContext(ctx, &followers, query, userID)
-    return followers, err
+// List a page of a user's followers, most recent first, hiding users in a block with the viewer
+func ListFollowers(ctx context.Context, db *sqlx.DB, userID, viewerID string, cursor Cursor, limit int) (FollowPage, error) {
+    query := `SELECT ` + userSummaryColumns + `, f.created_at AS followed_at
+              FROM followings f JOIN users u ON u.user_id = f.follower_id
+              WHERE f.following_id = $1 AND (f.created_at, f.follower_id) < ($2, $3) AND ` + NotBlocked("u.user_id", "$5") + `
+              ORDER BY f.created_at DESC, f.follower_id DESC LIMIT $4`
+    return listFollows(ctx, db, query, userID, viewerID, cursor, limit)
+}
+
+// List a page of the users a user follows, most recent first, hiding users in a block with the viewer
+func ListFollowings(ctx context.Context, db *sqlx.DB, userID, viewerID string, cursor Cursor, limit int) (FollowPage, error) {
+    query := `SELECT ` + userSummaryColumns + `, f.created_at AS followed_at
+              FROM followings f JOIN users u ON u.user_id = f.following_id
+              WHERE f.follower_id = $1 AND (f.created_at, f.following_id) < ($2, $3) AND ` + NotBlocked("u.user_id", "$5") + `
+              ORDER BY f.created_at DESC, f.following_id DESC LIMIT $4`
+    return listFollows(ctx, db, query, userID, viewerID, cursor, limit)
 }
 
-// List followings for a user
-func ListFollowings(ctx context.Context, db *sqlx.DB, userID string) ([]Following, error) {
-    var followings []Following
-    query := `SELECT * FROM followings WHERE follower_id = $1 ORDER BY following_id`
-    err := db.SelectContext(ctx, &followings, query, userID)
-    return followings, err
+// listFollows runs a follow list query fetching limit+1 rows and builds the page
+func listFollows(ctx context.Context, db *sqlx.DB, query, userID, viewerID string, cursor Cursor, limit int) (FollowPage, error) {
+    var entries []FollowEntry
+    if err := db.SelectContext(ctx, &entries, query, userID, cursor.Time, cursor.ID, limit+1, viewerID); err != nil {
+        return FollowPage{}, err
+    }
+
+    page := FollowPage{Users: entries}
+    if len(entries) > limit {
+        page.Users = entries[:limit]
+        last := page.Users[limit-1]
+        page.NextCursor = Cursor{Time: last.FollowedAt, ID: last.UserID}.Encode()
+    }
+    if page.Users == nil {
+        page.Users = []FollowEntry{}
+    }
+    return page, nil
 }
@@ -0,0 +1,364 @@
+package types
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// MaxGroupMembers is the most current members a group conversation may have, including its admins
+var MaxGroupMembers = EnvInt("GROUP_MEMBER_LIMIT", 32)
+
+// System notice actions
+const (
+	NoticeGroupCreated  = "group_created"
+	NoticeMemberAdded   = "member_added"
+	NoticeMemberRemoved = "member_removed"
+	NoticeMemberLeft    = "member_left"
+	NoticeAdminAdded    = "admin_added"
+	NoticeAdminRemoved  = "admin_removed"
+	NoticeGroupRenamed  = "group_renamed"
+	NoticeAvatarChanged = "avatar_changed"
+	NoticeTimerChanged  = "timer_changed"
+)
+
+var (
+	ErrGroupFull  = errors.New("group member limit reached")
+	ErrLastAdmin  = errors.New("a group needs at least one admin")
+	ErrNotInGroup = errors.New("user is not a member of this group")
+)
+
+// SystemNotice is the content of a system message: what changed, the member it concerns and any new value.
+// Clients render it with the names they already know. A timer change carries the new timer in seconds, empty when turned off.
+type SystemNotice struct {
+	Action string `json:"action"`
+	UserID string `json:"user_id,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// GroupMember is a current member of a group conversation
+type GroupMember struct {
+	UserSummary
+	Role     string    `json:"role" db:"role"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// ValidateGroupName trims a group name and checks its length
+func ValidateGroupName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 100 {
+		return "", errors.New("group name must be between 1 and 100 characters")
+	}
+	return name, nil
+}
+
+// insertSystemMessage records a notice in a conversation at the given time, attributed to actorID
+func insertSystemMessage(ctx context.Context, tx *sqlx.Tx, conversationID, actorID string, notice SystemNotice, at time.Time) (Message, error) {
+	content, err := json.Marshal(notice)
+	if err != nil {
+		return Message{}, err
+	}
+	m := Message{
+		MessageID:      uuid.New().String(),
+		ConversationID: conversationID,
+		SenderID:       actorID,
+		ContentType:    MessageTypeSystem,
+		Content:        string(content),
+		Timestamp:      at,
+	}
+	return m, insertMessage(ctx, tx, &m)
+}
+
+// lockGroup locks a group conversation for a membership change, returning sql.ErrNoRows if it isn't a group
+func lockGroup(ctx context.Context, tx *sqlx.Tx, conversationID string) error {
+	var id string
+	query := `SELECT conversation_id FROM conversations WHERE conversation_id = $1 AND kind = $2 FOR UPDATE`
+	return tx.GetContext(ctx, &id, query, conversationID, ConversationGroup)
+}
+
+// countMembers returns how many current members a conversation has
+func countMembers(ctx context.Context, tx *sqlx.Tx, conversationID string) (int, error) {
+	var n int
+	err := tx.GetContext(ctx, &n, `SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = $1 AND left_at IS NULL`, conversationID)
+	return n, err
+}
+
+// uniqueMembers drops duplicates and excludeID from userIDs
+func uniqueMembers(userIDs []string, excludeID string) []string {
+	seen := map[string]bool{excludeID: true}
+	var unique []string
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// CreateGroup starts a group conversation administered by creatorID with the given members
+func CreateGroup(ctx context.Context, db *sqlx.DB, creatorID, name string, avatarURL *string, memberIDs []string) (Conversation, []Message, error) {
+	memberIDs = uniqueMembers(memberIDs, creatorID)
+	if len(memberIDs)+1 > MaxGroupMembers {
+		return Conversation{}, nil, ErrGroupFull
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var conv Conversation
+	query := `INSERT INTO conversations (conversation_id, kind, name, avatar_url, created_by, created_at, last_message_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $6) RETURNING *`
+	if err := tx.GetContext(ctx, &conv, query, uuid.New().String(), ConversationGroup, name, avatarURL, creatorID, now); err != nil {
+		return Conversation{}, nil, err
+	}
+
+	query = `INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at, last_read_at)
+			 SELECT $1, member_id, CASE WHEN member_id = $2 THEN $4 ELSE $5 END, $3, $3 FROM unnest($6::uuid[]) AS member_id`
+	members := append([]string{creatorID}, memberIDs...)
+	if _, err := tx.ExecContext(ctx, query, conv.ConversationID, creatorID, now, RoleAdmin, RoleMember, pq.Array(members)); err != nil {
+		return Conversation{}, nil, err
+	}
+
+	created, err := insertSystemMessage(ctx, tx, conv.ConversationID, creatorID, SystemNotice{Action: NoticeGroupCreated, Value: name}, now)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+
+	return conv, []Message{created}, tx.Commit()
+}
+
+// AddGroupMembers adds users to a group, or brings back former members, with a system message for each.
+// Users who are already members are skipped.
+func AddGroupMembers(ctx context.Context, db *sqlx.DB, conversationID, actorID string, userIDs []string) ([]Message, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockGroup(ctx, tx, conversationID); err != nil {
+		return nil, err
+	}
+
+	var current []string
+	query := `SELECT user_id FROM conversation_participants WHERE conversation_id = $1 AND left_at IS NULL AND user_id = ANY($2)`
+	if err := tx.SelectContext(ctx, &current, query, conversationID, pq.Array(userIDs)); err != nil {
+		return nil, err
+	}
+	isMember := make(map[string]bool, len(current))
+	for _, id := range current {
+		isMember[id] = true
+	}
+	var added []string
+	for _, id := range uniqueMembers(userIDs, actorID) {
+		if !isMember[id] {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return nil, tx.Commit()
+	}
+
+	count, err := countMembers(ctx, tx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	if count+len(added) > MaxGroupMembers {
+		return nil, ErrGroupFull
+	}
+
+	// Returning members start over: they only see history from when they rejoin
+	now := time.Now()
+	query = `INSERT INTO conversation_participants (conversation_id, user_id, role, joined_at, last_read_at)
+			 SELECT $1, member_id, $2, $3, $3 FROM unnest($4::uuid[]) AS member_id
+			 ON CONFLICT (conversation_id, user_id) DO UPDATE SET role = EXCLUDED.role, joined_at = EXCLUDED.joined_at, left_at = NULL, last_read_at = EXCLUDED.last_read_at`
+	if _, err := tx.ExecContext(ctx, query, conversationID, RoleMember, now, pq.Array(added)); err != nil {
+		return nil, err
+	}
+
+	var notices []Message
+	for _, id := range added {
+		m, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: NoticeMemberAdded, UserID: id}, now)
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, m)
+	}
+
+	return notices, tx.Commit()
+}
+
+// RemoveGroupMember takes userID out of a group, as a removal by actorID or as leaving when they are the same user.
+// The member keeps access to history up to and including the notice of their removal. If the last admin goes,
+// the longest-standing remaining member becomes admin.
+func RemoveGroupMember(ctx context.Context, db *sqlx.DB, conversationID, actorID, userID string) ([]Message, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockGroup(ctx, tx, conversationID); err != nil {
+		return nil, err
+	}
+
+	var role string
+	query := `SELECT role FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 AND left_at IS NULL`
+	if err := tx.GetContext(ctx, &role, query, conversationID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotInGroup
+		}
+		return nil, err
+	}
+
+	action := NoticeMemberRemoved
+	if actorID == userID {
+		action = NoticeMemberLeft
+	}
+	now := time.Now()
+	removed, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: action, UserID: userID}, now)
+	if err != nil {
+		return nil, err
+	}
+	notices := []Message{removed}
+
+	query = `UPDATE conversation_participants SET left_at = $3, role = $4 WHERE conversation_id = $1 AND user_id = $2`
+	if _, err := tx.ExecContext(ctx, query, conversationID, userID, now, RoleMember); err != nil {
+		return nil, err
+	}
+
+	if role == RoleAdmin {
+		var successor string
+		query = `SELECT user_id FROM conversation_participants WHERE conversation_id = $1 AND left_at IS NULL
+				 AND NOT EXISTS (SELECT 1 FROM conversation_participants a WHERE a.conversation_id = $1 AND a.left_at IS NULL AND a.role = $2)
+				 ORDER BY joined_at, user_id LIMIT 1`
+		err := tx.GetContext(ctx, &successor, query, conversationID, RoleAdmin)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil {
+			if _, err := tx.ExecContext(ctx, `UPDATE conversation_participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`, conversationID, successor, RoleAdmin); err != nil {
+				return nil, err
+			}
+			promoted, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: NoticeAdminAdded, UserID: successor}, now)
+			if err != nil {
+				return nil, err
+			}
+			notices = append(notices, promoted)
+		}
+	}
+
+	return notices, tx.Commit()
+}
+
+// SetGroupRole makes a member an admin or a regular member
+func SetGroupRole(ctx context.Context, db *sqlx.DB, conversationID, actorID, userID, role string) ([]Message, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockGroup(ctx, tx, conversationID); err != nil {
+		return nil, err
+	}
+
+	var current string
+	query := `SELECT role FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2 AND left_at IS NULL`
+	if err := tx.GetContext(ctx, &current, query, conversationID, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotInGroup
+		}
+		return nil, err
+	}
+	if current == role {
+		return nil, tx.Commit()
+	}
+
+	if role == RoleMember {
+		var admins int
+		query = `SELECT COUNT(*) FROM conversation_participants WHERE conversation_id = $1 AND left_at IS NULL AND role = $2`
+		if err := tx.GetContext(ctx, &admins, query, conversationID, RoleAdmin); err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE conversation_participants SET role = $3 WHERE conversation_id = $1 AND user_id = $2`, conversationID, userID, role); err != nil {
+		return nil, err
+	}
+
+	action := NoticeAdminAdded
+	if role == RoleMember {
+		action = NoticeAdminRemoved
+	}
+	notice, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: action, UserID: userID}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return []Message{notice}, tx.Commit()
+}
+
+// UpdateGroup changes a group's name and avatar where given, with a system message for each change
+func UpdateGroup(ctx context.Context, db *sqlx.DB, conversationID, actorID string, name, avatarURL *string) (Conversation, []Message, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Conversation{}, nil, err
+	}
+	defer tx.Rollback()
+
+	if err := lockGroup(ctx, tx, conversationID); err != nil {
+		return Conversation{}, nil, err
+	}
+
+	var conv Conversation
+	query := `UPDATE conversations SET name = COALESCE($2, name), avatar_url = COALESCE($3, avatar_url) WHERE conversation_id = $1 RETURNING *`
+	if err := tx.GetContext(ctx, &conv, query, conversationID, name, avatarURL); err != nil {
+		return Conversation{}, nil, err
+	}
+
+	now := time.Now()
+	var notices []Message
+	if name != nil {
+		m, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: NoticeGroupRenamed, Value: *name}, now)
+		if err != nil {
+			return Conversation{}, nil, err
+		}
+		notices = append(notices, m)
+	}
+	if avatarURL != nil {
+		m, err := insertSystemMessage(ctx, tx, conversationID, actorID, SystemNotice{Action: NoticeAvatarChanged}, now)
+		if err != nil {
+			return Conversation{}, nil, err
+		}
+		notices = append(notices, m)
+	}
+
+	return conv, notices, tx.Commit()
+}
+
+// ListGroupMembers returns the current members of a conversation, admins first
+func ListGroupMembers(ctx context.Context, db *sqlx.DB, conversationID string) ([]GroupMember, error) {
+	var members []GroupMember
+	query := `SELECT ` + userSummaryColumns + `, cp.role, cp.joined_at FROM conversation_participants cp
+			  JOIN users u ON u.user_id = cp.user_id
+			  WHERE cp.conversation_id = $1 AND cp.left_at IS NULL
+			  ORDER BY cp.role = 'admin' DESC, cp.joined_at, cp.user_id`
+	err := db.SelectContext(ctx, &members, query, conversationID)
+	return members, err
+}
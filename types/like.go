@@ -2,23 +2,106 @@ package types
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
 	"github.com/jmoiron/sqlx"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-// Like represents a like on a post
+// Reaction types. ReactionHeart is what the like endpoints use.
+const ReactionHeart = "heart"
+
+// ReactionTypes is the configured set of allowed reactions, from the comma-separated REACTION_TYPES variable
+var ReactionTypes = EnvList("REACTION_TYPES", []string{ReactionHeart, "laugh", "wow", "sad", "angry", "fire"})
+
+// ValidReaction reports whether reaction is in the configured set
+func ValidReaction(reaction string) bool {
+	for _, r := range ReactionTypes {
+		if r == reaction {
+			return true
+		}
+	}
+	return false
+}
+
+// Like represents a reaction on a post. Each user has at most one per post.
 type Like struct {
 	LikeID    string    `json:"like_id" db:"like_id"`
 	UserID    string    `json:"user_id" db:"user_id"`
 	PostID    string    `json:"post_id" db:"post_id"`
+	Reaction  string    `json:"reaction" db:"reaction"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
-// Create a new like
-func (l *Like) Create(ctx context.Context, db *sqlx.DB) error {
-	query := `INSERT INTO likes (like_id, user_id, post_id, created_at) VALUES (:like_id, :user_id, :post_id, :created_at)`
-	_, err := db.NamedExecContext(ctx, query, l)
+// LikeState is a viewer's reaction on a post along with the post's reaction counts
+type LikeState struct {
+	PostID         string         `json:"post_id" db:"post_id"`
+	Liked          bool           `json:"liked" db:"-"`
+	LikeCount      int            `json:"like_count" db:"like_count"`
+	Reactions      map[string]int `json:"reactions" db:"-"`
+	ViewerReaction string         `json:"viewer_reaction,omitempty" db:"viewer_reaction"`
+}
+
+// Create a reaction, replacing the user's previous reaction on the post, and keep the post's counts in step.
+// Reports whether this was the user's first reaction on the post, as opposed to a change or repeat.
+func (l *Like) Create(ctx context.Context, db *sqlx.DB) (bool, error) {
+	if l.Reaction == "" {
+		l.Reaction = ReactionHeart
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO likes (like_id, user_id, post_id, reaction, created_at) VALUES (:like_id, :user_id, :post_id, :reaction, :created_at) ON CONFLICT (user_id, post_id) DO NOTHING`
+	res, err := tx.NamedExecContext(ctx, query, l)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	inserted := affected > 0
+	if inserted {
+		if _, err := tx.ExecContext(ctx, `UPDATE posts SET like_count = like_count + 1 WHERE post_id = $1`, l.PostID); err != nil {
+			return false, err
+		}
+	} else {
+		var previous string
+		query = `SELECT reaction FROM likes WHERE user_id = $1 AND post_id = $2 FOR UPDATE`
+		if err := tx.GetContext(ctx, &previous, query, l.UserID, l.PostID); err != nil {
+			return false, err
+		}
+		if previous == l.Reaction {
+			return false, nil
+		}
+
+		query = `UPDATE likes SET reaction = $1, created_at = $2 WHERE user_id = $3 AND post_id = $4`
+		if _, err := tx.ExecContext(ctx, query, l.Reaction, l.CreatedAt, l.UserID, l.PostID); err != nil {
+			return false, err
+		}
+		if err := adjustReactionCount(ctx, tx, l.PostID, previous, -1); err != nil {
+			return false, err
+		}
+	}
+
+	if err := adjustReactionCount(ctx, tx, l.PostID, l.Reaction, 1); err != nil {
+		return false, err
+	}
+	return inserted, tx.Commit()
+}
+
+// adjustReactionCount adds delta to a post's count for one reaction type
+func adjustReactionCount(ctx context.Context, tx *sqlx.Tx, postID, reaction string, delta int) error {
+	query := `INSERT INTO post_reaction_counts (post_id, reaction, count) VALUES ($1, $2, GREATEST($3, 0))
+			  ON CONFLICT (post_id, reaction) DO UPDATE SET count = GREATEST(post_reaction_counts.count + $3, 0)`
+	_, err := tx.ExecContext(ctx, query, postID, reaction, delta)
 	return err
 }
 
@@ -30,23 +113,175 @@ func (l *Like) Read(ctx context.Context, db *sqlx.DB, likeID uuid.UUID) error {
 
 // Delete a like by ID
 func (l *Like) Delete(ctx context.Context, db *sqlx.DB, likeID uuid.UUID) error {
-	query := `DELETE FROM likes WHERE like_id = $1`
-	_, err := db.ExecContext(ctx, query, likeID)
+	_, err := deleteLike(ctx, db, `DELETE FROM likes WHERE like_id = $1 RETURNING post_id, reaction`, likeID)
 	return err
 }
 
-// List likes for a post
-func ListLikesForPost(ctx context.Context, db *sqlx.DB, postID string) ([]Like, error) {
+// Remove a user's reaction from a post. Reports false if the user hadn't reacted.
+func DeleteLike(ctx context.Context, db *sqlx.DB, userID, postID string) (bool, error) {
+	return deleteLike(ctx, db, `DELETE FROM likes WHERE user_id = $1 AND post_id = $2 RETURNING post_id, reaction`, userID, postID)
+}
+
+// deleteLike runs a like deletion returning post_id and reaction, and decrements that post's counts
+func deleteLike(ctx context.Context, db *sqlx.DB, query string, args ...interface{}) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var deleted Like
+	if err := tx.GetContext(ctx, &deleted, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE posts SET like_count = GREATEST(like_count - 1, 0) WHERE post_id = $1`, deleted.PostID); err != nil {
+		return false, err
+	}
+	if err := adjustReactionCount(ctx, tx, deleted.PostID, deleted.Reaction, -1); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ReadLikeState loads userID's reaction on postID and the post's reaction counts
+func ReadLikeState(ctx context.Context, db *sqlx.DB, userID, postID string) (LikeState, error) {
+	var state LikeState
+	query := `SELECT p.post_id, p.like_count, COALESCE((SELECT l.reaction FROM likes l WHERE l.post_id = p.post_id AND l.user_id = $1), '') AS viewer_reaction
+			  FROM posts p WHERE p.post_id = $2`
+	if err := db.GetContext(ctx, &state, query, userID, postID); err != nil {
+		return state, err
+	}
+	state.Liked = state.ViewerReaction != ""
+
+	counts, err := ListReactionCounts(ctx, db, postID)
+	state.Reactions = counts[postID]
+	return state, err
+}
+
+// ListReactionCounts returns the non-zero reaction counts of each post, keyed by post ID then reaction
+func ListReactionCounts(ctx context.Context, db *sqlx.DB, postIDs ...string) (map[string]map[string]int, error) {
+	var rows []struct {
+		PostID   string `db:"post_id"`
+		Reaction string `db:"reaction"`
+		Count    int    `db:"count"`
+	}
+	query := `SELECT post_id, reaction, count FROM post_reaction_counts WHERE post_id = ANY($1) AND count > 0`
+	if err := db.SelectContext(ctx, &rows, query, pq.Array(postIDs)); err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]map[string]int, len(postIDs))
+	for _, id := range postIDs {
+		counts[id] = map[string]int{}
+	}
+	for _, row := range rows {
+		counts[row.PostID][row.Reaction] = row.Count
+	}
+	return counts, nil
+}
+
+// AttachReactions fills in reaction counts and the viewer's own reaction on posts and their originals.
+// Plain reposts carry their original's counters.
+func AttachReactions(ctx context.Context, db *sqlx.DB, viewerID string, posts ...*Post) error {
+	all := make([]*Post, 0, len(posts))
+	for _, p := range posts {
+		all = append(all, p)
+		if p.Original != nil {
+			all = append(all, p.Original)
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(all))
+	for i, p := range all {
+		ids[i] = p.Target()
+	}
+
+	counts, err := ListReactionCounts(ctx, db, ids...)
+	if err != nil {
+		return err
+	}
+	viewerReactions, err := ListViewerReactions(ctx, db, viewerID, ids...)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range all {
+		p.Reactions = counts[p.Target()]
+		p.ViewerReaction = viewerReactions[p.Target()]
+		if p.Target() != p.PostID && p.Original != nil {
+			p.LikeCount = p.Original.LikeCount
+		}
+	}
+	return nil
+}
+
+// ListViewerReactions returns userID's reaction on each of postIDs they reacted to
+func ListViewerReactions(ctx context.Context, db *sqlx.DB, userID string, postIDs ...string) (map[string]string, error) {
+	var likes []Like
+	query := `SELECT * FROM likes WHERE user_id = $1 AND post_id = ANY($2)`
+	if err := db.SelectContext(ctx, &likes, query, userID, pq.Array(postIDs)); err != nil {
+		return nil, err
+	}
+
+	reactions := make(map[string]string, len(likes))
+	for _, l := range likes {
+		reactions[l.PostID] = l.Reaction
+	}
+	return reactions, nil
+}
+
+// ReconcileLikeCounts repairs posts and comments whose like or reaction counts have drifted from the likes table, returning how many counters were fixed
+func ReconcileLikeCounts(ctx context.Context, db *sqlx.DB) (int64, error) {
+	queries := []string{
+		`UPDATE posts p SET like_count = c.actual
+		 FROM (SELECT p2.post_id, COUNT(l.like_id) AS actual FROM posts p2 LEFT JOIN likes l ON l.post_id = p2.post_id GROUP BY p2.post_id) c
+		 WHERE p.post_id = c.post_id AND p.like_count <> c.actual`,
+		`INSERT INTO post_reaction_counts (post_id, reaction, count)
+		 SELECT post_id, reaction, COUNT(*) FROM likes GROUP BY post_id, reaction
+		 ON CONFLICT (post_id, reaction) DO UPDATE SET count = EXCLUDED.count WHERE post_reaction_counts.count <> EXCLUDED.count`,
+		`UPDATE post_reaction_counts rc SET count = 0
+		 WHERE rc.count <> 0 AND NOT EXISTS (SELECT 1 FROM likes l WHERE l.post_id = rc.post_id AND l.reaction = rc.reaction)`,
+		`UPDATE comments c SET like_count = a.actual
+		 FROM (SELECT c2.comment_id, COUNT(cl.user_id) AS actual FROM comments c2 LEFT JOIN comment_likes cl ON cl.comment_id = c2.comment_id GROUP BY c2.comment_id) a
+		 WHERE c.comment_id = a.comment_id AND c.like_count <> a.actual`,
+	}
+
+	var fixed int64
+	for _, query := range queries {
+		res, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return fixed, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fixed, err
+		}
+		fixed += n
+	}
+	return fixed, nil
+}
+
+// List likes for a post the viewer may see
+func ListLikesForPost(ctx context.Context, db *sqlx.DB, postID, viewerID string) ([]Like, error) {
 	var likes []Like
-	query := `SELECT * FROM likes WHERE post_id = $1 ORDER BY created_at DESC`
-	err := db.SelectContext(ctx, &likes, query, postID)
+	query := `SELECT l.* FROM likes l JOIN posts p ON p.post_id = l.post_id
+			  WHERE l.post_id = $1 AND ` + VisibleTo("$2") + ` AND ` + NotBlocked("l.user_id", "$2") + ` ORDER BY l.created_at DESC`
+	err := db.SelectContext(ctx, &likes, query, postID, viewerID)
 	return likes, err
 }
 
-// List likes by a user
-func ListLikesByUser(ctx context.Context, db *sqlx.DB, userID string) ([]Like, error) {
+// List likes by a user on posts the viewer may see
+func ListLikesByUser(ctx context.Context, db *sqlx.DB, userID, viewerID string) ([]Like, error) {
 	var likes []Like
-	query := `SELECT * FROM likes WHERE user_id = $1 ORDER BY created_at DESC`
-	err := db.SelectContext(ctx, &likes, query, userID)
+	query := `SELECT l.* FROM likes l JOIN posts p ON p.post_id = l.post_id
+			  WHERE l.user_id = $1 AND ` + VisibleTo("$2") + ` AND ` + NotBlocked("l.user_id", "$2") + ` ORDER BY l.created_at DESC`
+	err := db.SelectContext(ctx, &likes, query, userID, viewerID)
 	return likes, err
 }
@@ -0,0 +1,246 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/jpeg"
+	"time"
+
+	// Register the decoders used for previews
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Media kinds, matching the message content types they can be attached to
+const (
+	MediaImage = "image"
+	MediaVideo = "video"
+	MediaAudio = "audio"
+)
+
+// MediaTypes maps each accepted upload MIME type to its kind. Types are sniffed from the file, never taken from the client.
+var MediaTypes = map[string]string{
+	"image/jpeg":      MediaImage,
+	"image/png":       MediaImage,
+	"image/gif":       MediaImage,
+	"image/webp":      MediaImage,
+	"video/mp4":       MediaVideo,
+	"video/quicktime": MediaVideo,
+	"video/webm":      MediaVideo,
+	"audio/mpeg":      MediaAudio,
+	"audio/mp4":       MediaAudio,
+	"audio/x-m4a":     MediaAudio,
+	"audio/aac":       MediaAudio,
+	"audio/ogg":       MediaAudio,
+	"audio/wav":       MediaAudio,
+	"audio/webm":      MediaAudio,
+}
+
+// MediaMaxBytes is the largest upload accepted for each kind
+var MediaMaxBytes = map[string]int64{
+	MediaImage: int64(EnvInt("MEDIA_MAX_IMAGE_BYTES", 10<<20)),
+	MediaVideo: int64(EnvInt("MEDIA_MAX_VIDEO_BYTES", 50<<20)),
+	MediaAudio: int64(EnvInt("MEDIA_MAX_AUDIO_BYTES", 10<<20)),
+}
+
+const (
+	// previewSize is the longest side of an image preview
+	previewSize = 320
+	// maxImagePixels guards against images that are small on disk but huge once decoded
+	maxImagePixels = 50_000_000
+)
+
+var (
+	ErrMediaType     = errors.New("unsupported media type")
+	ErrMediaTooLarge = errors.New("media is too large")
+)
+
+// Media is an uploaded file that can be attached to messages. Data and Preview are only loaded when serving the file.
+type Media struct {
+	MediaID     string    `json:"media_id" db:"media_id"`
+	UploaderID  string    `json:"-" db:"uploader_id"`
+	Kind        string    `json:"kind" db:"kind"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	Size        int64     `json:"size" db:"size"`
+	Width       *int      `json:"width,omitempty" db:"width"`
+	Height      *int      `json:"height,omitempty" db:"height"`
+	HasPreview  bool      `json:"-" db:"has_preview"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	URL         string    `json:"url" db:"-"`
+	PreviewURL  string    `json:"preview_url,omitempty" db:"-"`
+	Data        []byte    `json:"-" db:"data"`
+	Preview     []byte    `json:"-" db:"preview"`
+}
+
+// mediaColumns selects a Media without its file contents
+const mediaColumns = `media_id, uploader_id, kind, content_type, size, width, height, preview IS NOT NULL AS has_preview, created_at`
+
+// setURLs fills in where the media and its preview are served from
+func (m *Media) setURLs() {
+	m.URL = "/api/v1/media/" + m.MediaID
+	if m.HasPreview {
+		m.PreviewURL = m.URL + "/preview"
+	}
+}
+
+// Prepare sniffs the type of m.Data, checks it against the accepted types and size limits and renders a preview for images
+func (m *Media) Prepare() error {
+	detected := mimetype.Detect(m.Data)
+	kind, ok := MediaTypes[detected.String()]
+	for parent := detected.Parent(); !ok && parent != nil; parent = parent.Parent() {
+		kind, ok = MediaTypes[parent.String()]
+	}
+	if !ok {
+		return ErrMediaType
+	}
+	if int64(len(m.Data)) > MediaMaxBytes[kind] {
+		return ErrMediaTooLarge
+	}
+
+	m.Kind = kind
+	m.ContentType = detected.String()
+	m.Size = int64(len(m.Data))
+	if kind == MediaImage {
+		return m.renderPreview()
+	}
+	return nil
+}
+
+// renderPreview records an image's dimensions and renders a small JPEG of it. Formats the standard library
+// can't decode, such as WebP, are stored without a preview.
+func (m *Media) renderPreview() error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return ErrMediaTooLarge
+	}
+	m.Width, m.Height = &config.Width, &config.Height
+
+	src, _, err := image.Decode(bytes.NewReader(m.Data))
+	if err != nil {
+		return nil
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, downscale(src, previewSize), &jpeg.Options{Quality: 75}); err != nil {
+		return err
+	}
+	m.Preview = out.Bytes()
+	m.HasPreview = true
+	return nil
+}
+
+// downscale shrinks src so its longest side is at most size, averaging the source pixels behind each output pixel
+func downscale(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*h/dh, b.Min.Y+(y+1)*h/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*w/dw, b.Min.X+(x+1)*w/dw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// Create stores an uploaded file
+func (m *Media) Create(ctx context.Context, db *sqlx.DB) error {
+	query := `INSERT INTO media (media_id, uploader_id, kind, content_type, size, width, height, data, preview, created_at)
+			  VALUES (:media_id, :uploader_id, :kind, :content_type, :size, :width, :height, :data, :preview, :created_at)`
+	if _, err := db.NamedExecContext(ctx, query, m); err != nil {
+		return err
+	}
+	m.setURLs()
+	return nil
+}
+
+// ReadOwnMedia loads the details of a file uploaded by userID
+func ReadOwnMedia(ctx context.Context, db *sqlx.DB, mediaID, userID string) (Media, error) {
+	var media Media
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE media_id = $1 AND uploader_id = $2`
+	if err := db.GetContext(ctx, &media, query, mediaID, userID); err != nil {
+		return media, err
+	}
+	media.setURLs()
+	return media, nil
+}
+
+// ReadMediaFile loads a file's contents, or its preview when preview is set, for a viewer who uploaded it or can see
+// a message it is attached to. It returns sql.ErrNoRows otherwise.
+func ReadMediaFile(ctx context.Context, db *sqlx.DB, mediaID, viewerID string, preview bool) (contentType string, data []byte, err error) {
+	var file struct {
+		ContentType string `db:"content_type"`
+		Data        []byte `db:"data"`
+	}
+	column, typeColumn := "md.data", "md.content_type"
+	if preview {
+		column, typeColumn = "md.preview", "'image/jpeg'"
+	}
+	query := `SELECT ` + typeColumn + ` AS content_type, ` + column + ` AS data FROM media md
+			  WHERE md.media_id = $1 AND ` + column + ` IS NOT NULL AND (md.uploader_id = $2 OR EXISTS (
+				SELECT 1 FROM messages m
+				JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $2
+				WHERE m.media_id = md.media_id AND ` + memberCanSee("cp", "m") + `))`
+	err = db.GetContext(ctx, &file, query, mediaID, viewerID)
+	return file.ContentType, file.Data, err
+}
+
+// DeleteOrphanedMedia removes uploads older than age that aren't attached to any message, either because they never
+// were or because the message was unsent
+func DeleteOrphanedMedia(ctx context.Context, db *sqlx.DB, age time.Duration) (int64, error) {
+	query := `DELETE FROM media md WHERE md.created_at < NOW() - make_interval(secs => $1)
+			  AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.media_id = md.media_id)`
+	res, err := db.ExecContext(ctx, query, age.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// listMedia loads the details of the given files
+func listMedia(ctx context.Context, db *sqlx.DB, mediaIDs []string) ([]Media, error) {
+	var media []Media
+	query := `SELECT ` + mediaColumns + ` FROM media WHERE media_id = ANY($1)`
+	if err := db.SelectContext(ctx, &media, query, pq.Array(mediaIDs)); err != nil {
+		return nil, err
+	}
+	for i := range media {
+		media[i].setURLs()
+	}
+	return media, nil
+}
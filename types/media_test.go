@@ -0,0 +1,129 @@
+package types
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pixelBomb is a GIF of a single pixel whose header claims a 65535x65535 canvas
+func pixelBomb(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data[6:8], 65535)
+	binary.LittleEndian.PutUint16(data[8:10], 65535)
+	return data
+}
+
+func TestMediaPrepare(t *testing.T) {
+	wav := append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		kind    string
+		preview bool
+		err     error
+	}{
+		{"small png", encodePNG(t, 40, 20), MediaImage, true, nil},
+		{"large png", encodePNG(t, 800, 400), MediaImage, true, nil},
+		{"wav", wav, MediaAudio, false, nil},
+		{"plain text", []byte("just some text, not an upload"), "", false, ErrMediaType},
+		{"html", []byte("<html><body>hi</body></html>"), "", false, ErrMediaType},
+		{"empty", nil, "", false, ErrMediaType},
+		{"pixel bomb", pixelBomb(t), "", false, ErrMediaTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Media{Data: tt.data}
+			err := m.Prepare()
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Prepare() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if m.Kind != tt.kind {
+				t.Errorf("Kind = %q, want %q", m.Kind, tt.kind)
+			}
+			if m.Size != int64(len(tt.data)) {
+				t.Errorf("Size = %d, want %d", m.Size, len(tt.data))
+			}
+			if m.HasPreview != tt.preview || (len(m.Preview) > 0) != tt.preview {
+				t.Errorf("HasPreview = %v with %d preview bytes, want %v", m.HasPreview, len(m.Preview), tt.preview)
+			}
+			if tt.preview && (m.Width == nil || m.Height == nil) {
+				t.Error("images should record their dimensions")
+			}
+		})
+	}
+}
+
+func TestMediaPrepareEnforcesSizeLimit(t *testing.T) {
+	data := encodePNG(t, 10, 10)
+	limit := MediaMaxBytes[MediaImage]
+	t.Cleanup(func() { MediaMaxBytes[MediaImage] = limit })
+
+	MediaMaxBytes[MediaImage] = int64(len(data))
+	if err := (&Media{Data: data}).Prepare(); err != nil {
+		t.Errorf("Prepare() at the limit = %v, want nil", err)
+	}
+	MediaMaxBytes[MediaImage] = int64(len(data)) - 1
+	if err := (&Media{Data: data}).Prepare(); !errors.Is(err, ErrMediaTooLarge) {
+		t.Errorf("Prepare() over the limit = %v, want %v", err, ErrMediaTooLarge)
+	}
+}
+
+func TestDownscale(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h, size   int
+		wantW, wantH int
+	}{
+		{"smaller than size", 100, 50, 320, 100, 50},
+		{"exactly size", 320, 320, 320, 320, 320},
+		{"wide", 640, 320, 320, 320, 160},
+		{"tall", 300, 1200, 320, 80, 320},
+		{"extremely wide", 4000, 2, 320, 320, 1},
+		{"extremely tall", 1, 5000, 320, 1, 320},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := downscale(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), tt.size).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("downscale(%dx%d, %d) = %dx%d, want %dx%d", tt.w, tt.h, tt.size, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestDownscaleAveragesPixels(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.RGBA{255, 255, 255, 255})
+	src.Set(1, 0, color.RGBA{255, 255, 255, 255})
+	src.Set(0, 1, color.RGBA{0, 0, 0, 255})
+	src.Set(1, 1, color.RGBA{0, 0, 0, 255})
+
+	got := color.RGBAModel.Convert(downscale(src, 1).At(0, 0)).(color.RGBA)
+	if got.R != 127 || got.G != 127 || got.B != 127 || got.A != 255 {
+		t.Errorf("downscale averaged to %v, want mid grey", got)
+	}
+}
@@ -2,28 +2,156 @@ package types
 
 import (
 	"context"
+	"errors"
 	"time"
 	"github.com/jmoiron/sqlx"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-// Message represents a message between users
+// Message content types. System messages are written by the server and can't be sent by clients.
+const (
+	MessageTypeText      = "text"
+	MessageTypeImage     = "image"
+	MessageTypeVideo     = "video"
+	MessageTypeAudio     = "audio"
+	MessageTypeLocation  = "location"
+	MessageTypePostShare = "post_share"
+	MessageTypeSystem    = "system"
+)
+
+// MessageEditWindow is how long after sending a message its sender may edit it
+var MessageEditWindow = EnvDuration("MESSAGE_EDIT_WINDOW", 15*time.Minute)
+
+// Message represents a message between users. Unsent messages are kept as tombstones with their content cleared
+// so the conversation still shows where they were.
 type Message struct {
-	MessageID   string    `json:"message_id" db:"message_id"`
-	SenderID    string    `json:"sender_id" db:"sender_id"`
-	ReceiverID  string    `json:"receiver_id" db:"receiver_id"`
-	ContentType string    `json:"content_type" db:"content_type"`
-	Content     string    `json:"content,omitempty" db:"content"`
-	MediaURL    string    `json:"media_url,omitempty" db:"media_url"`
-	Timestamp   time.Time `json:"timestamp" db:"timestamp"`
-	IsRead      bool      `json:"is_read" db:"is_read"`
-}
-
-// Create a new message
-func (m *Message) Create(ctx context.Context, db *sqlx.DB) error {
-	query := `INSERT INTO messages (message_id, sender_id, receiver_id, content_type, content, media_url, timestamp, is_read) VALUES (:message_id, :sender_id, :receiver_id, :content_type, :content, :media_url, :timestamp, :is_read)`
-	_, err := db.NamedExecContext(ctx, query, m)
-	return err
+	MessageID      string       `json:"message_id" db:"message_id"`
+	ConversationID string       `json:"conversation_id" db:"conversation_id"`
+	SenderID       string       `json:"sender_id" db:"sender_id"`
+	ReceiverID     *string      `json:"receiver_id,omitempty" db:"receiver_id"`
+	ContentType    string       `json:"content_type" db:"content_type"`
+	Content        string       `json:"content,omitempty" db:"content"`
+	MediaURL       string       `json:"media_url,omitempty" db:"media_url"`
+	MediaID        *string      `json:"media_id,omitempty" db:"media_id"`
+	SharedPostID   *string      `json:"shared_post_id,omitempty" db:"shared_post_id"`
+	Latitude       *float64     `json:"latitude,omitempty" db:"latitude"`
+	Longitude      *float64     `json:"longitude,omitempty" db:"longitude"`
+	LocationName   *string      `json:"location_name,omitempty" db:"location_name"`
+	Timestamp      time.Time    `json:"timestamp" db:"timestamp"`
+	IsRead         bool         `json:"is_read" db:"is_read"`
+	EditedAt       *time.Time   `json:"edited_at,omitempty" db:"edited_at"`
+	UnsentAt       *time.Time   `json:"unsent_at,omitempty" db:"unsent_at"`
+	ExpiresAt      *time.Time   `json:"expires_at,omitempty" db:"expires_at"`
+	Media          *Media       `json:"media,omitempty" db:"-"`
+	SharedPost     *PostPreview `json:"shared_post,omitempty" db:"-"`
+}
+
+// PostPreview is the summary of a shared post shown in a conversation
+type PostPreview struct {
+	PostID   string `json:"post_id" db:"post_id"`
+	UserID   string `json:"user_id" db:"user_id"`
+	Username string `json:"username" db:"username"`
+	Caption  string `json:"caption,omitempty" db:"caption"`
+	PhotoURL string `json:"photo_url,omitempty" db:"photo_url"`
+}
+
+// Validate checks that a message a client is sending carries what its content type needs and nothing else.
+// Media ownership and post visibility are checked against the database by the caller.
+func (m *Message) Validate() error {
+	hasMedia := m.MediaID != nil
+	hasPost := m.SharedPostID != nil
+	hasLocation := m.Latitude != nil || m.Longitude != nil
+
+	switch m.ContentType {
+	case MessageTypeText:
+		if m.Content == "" {
+			return errors.New("text messages need content")
+		}
+		if hasMedia || hasPost || hasLocation {
+			return errors.New("text messages can't carry attachments")
+		}
+	case MessageTypeImage, MessageTypeVideo, MessageTypeAudio:
+		if !hasMedia || hasPost || hasLocation {
+			return errors.New(m.ContentType + " messages need a media_id from an upload")
+		}
+	case MessageTypeLocation:
+		if m.Latitude == nil || m.Longitude == nil || hasMedia || hasPost {
+			return errors.New("location messages need a latitude and longitude")
+		}
+		if *m.Latitude < -90 || *m.Latitude > 90 || *m.Longitude < -180 || *m.Longitude > 180 {
+			return errors.New("invalid coordinates")
+		}
+		if m.LocationName != nil && len(*m.LocationName) > 100 {
+			return errors.New("location name is too long")
+		}
+	case MessageTypePostShare:
+		if !hasPost || hasMedia || hasLocation {
+			return errors.New("post shares need a shared_post_id")
+		}
+	default:
+		return errors.New("content_type must be one of text, image, video, audio, location or post_share")
+	}
+	return nil
+}
+
+// AttachMessageDetails loads the media and shared post previews of messages as viewerID sees them.
+// Shared posts the viewer isn't allowed to see get no preview.
+func AttachMessageDetails(ctx context.Context, db *sqlx.DB, viewerID string, messages ...*Message) error {
+	var mediaIDs, postIDs []string
+	for _, m := range messages {
+		if m.MediaID != nil {
+			mediaIDs = append(mediaIDs, *m.MediaID)
+		}
+		if m.SharedPostID != nil {
+			postIDs = append(postIDs, *m.SharedPostID)
+		}
+	}
+
+	if len(mediaIDs) > 0 {
+		media, err := listMedia(ctx, db, mediaIDs)
+		if err != nil {
+			return err
+		}
+		byID := make(map[string]*Media, len(media))
+		for i := range media {
+			byID[media[i].MediaID] = &media[i]
+		}
+		for _, m := range messages {
+			if m.MediaID != nil {
+				m.Media = byID[*m.MediaID]
+			}
+		}
+	}
+
+	if len(postIDs) > 0 {
+		var previews []PostPreview
+		query := `SELECT p.post_id, p.user_id, u.username, COALESCE(p.caption, p.content, '') AS caption, COALESCE(p.photo_url, '') AS photo_url
+				  FROM posts p JOIN users u ON u.user_id = p.user_id
+				  WHERE p.post_id = ANY($1) AND ` + VisibleTo("$2")
+		if err := db.SelectContext(ctx, &previews, query, pq.Array(postIDs), viewerID); err != nil {
+			return err
+		}
+		byID := make(map[string]*PostPreview, len(previews))
+		for i := range previews {
+			byID[previews[i].PostID] = &previews[i]
+		}
+		for _, m := range messages {
+			if m.SharedPostID != nil {
+				m.SharedPost = byID[*m.SharedPostID]
+			}
+		}
+	}
+	return nil
+}
+
+// MessagePtrs returns pointers to each message in messages, for attaching details in place
+func MessagePtrs(messages []Message) []*Message {
+	ptrs := make([]*Message, len(messages))
+	for i := range messages {
+		ptrs[i] = &messages[i]
+	}
+	return ptrs
 }
 
 // Read a message by ID
@@ -32,24 +160,56 @@ func (m *Message) Read(ctx context.Context, db *sqlx.DB, messageID uuid.UUID) er
 	return db.GetContext(ctx, m, query, messageID)
 }
 
-// Update a message
-func (m *Message) Update(ctx context.Context, db *sqlx.DB) error {
-	query := `UPDATE messages SET content_type=:content_type, content=:content, media_url=:media_url, timestamp=:timestamp, is_read=:is_read WHERE message_id=:message_id`
-	_, err := db.NamedExecContext(ctx, query, m)
+// Editable reports whether the message is text that hasn't been unsent and is still within its edit window
+func (m *Message) Editable(now time.Time) bool {
+	return m.ContentType == MessageTypeText && m.UnsentAt == nil && now.Sub(m.Timestamp) <= MessageEditWindow
+}
+
+// Edit replaces a message's content. The timestamp is left untouched; edited_at records when the change was made.
+func (m *Message) Edit(ctx context.Context, db *sqlx.DB, content string) error {
+	query := `UPDATE messages SET content = $1, edited_at = NOW() WHERE message_id = $2 AND unsent_at IS NULL RETURNING edited_at`
+	if err := db.GetContext(ctx, &m.EditedAt, query, content, m.MessageID); err != nil {
+		return err
+	}
+	m.Content = content
+	return nil
+}
+
+// Unsend removes a message for everyone, leaving a tombstone in its place. Detached media is removed by the orphaned media sweep.
+func (m *Message) Unsend(ctx context.Context, db *sqlx.DB) error {
+	query := `UPDATE messages SET content = '', media_url = '', media_id = NULL, shared_post_id = NULL,
+				latitude = NULL, longitude = NULL, location_name = NULL, unsent_at = NOW()
+			  WHERE message_id = $1 AND unsent_at IS NULL
+			  RETURNING *`
+	return db.GetContext(ctx, m, query, m.MessageID)
+}
+
+// HideMessage deletes a message for userID only; everyone else still sees it
+func HideMessage(ctx context.Context, db *sqlx.DB, userID, messageID string) error {
+	query := `INSERT INTO message_hides (user_id, message_id, hidden_at) VALUES ($1, $2, NOW()) ON CONFLICT DO NOTHING`
+	_, err := db.ExecContext(ctx, query, userID, messageID)
 	return err
 }
 
+// notHidden returns the condition that the message aliased m hasn't been deleted by the user in the parameter user
+func notHidden(m, user string) string {
+	return `NOT EXISTS (SELECT 1 FROM message_hides h WHERE h.message_id = ` + m + `.message_id AND h.user_id = ` + user + `)`
+}
+
+// ReadVisibleMessage loads a message from a conversation that userID can see and hasn't deleted for themselves,
+// returning sql.ErrNoRows otherwise
+func ReadVisibleMessage(ctx context.Context, db *sqlx.DB, conversationID, messageID, userID string) (Message, error) {
+	var message Message
+	query := `SELECT m.* FROM messages m
+			  JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $3
+			  WHERE m.message_id = $2 AND m.conversation_id = $1 AND ` + memberCanSee("cp", "m") + ` AND ` + notHidden("m", "$3")
+	err := db.GetContext(ctx, &message, query, conversationID, messageID, userID)
+	return message, err
+}
+
 // Delete a message by ID
 func (m *Message) Delete(ctx context.Context, db *sqlx.DB, messageID uuid.UUID) error {
 	query := `DELETE FROM messages WHERE message_id = $1`
 	_, err := db.ExecContext(ctx, query, messageID)
 	return err
 }
-
-// List messages between two users
-func ListMessages(ctx context.Context, db *sqlx.DB, senderID, receiverID string) ([]Message, error) {
-	var messages []Message
-	query := `SELECT * FROM messages WHERE (sender_id = $1 AND receiver_id = $2) OR (sender_id = $2 AND receiver_id = $1) ORDER BY timestamp DESC`
-	err := db.SelectContext(ctx, &messages, query, senderID, receiverID)
-	return messages, err
-}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestMessageValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		valid   bool
+	}{
+		{"text", Message{ContentType: MessageTypeText, Content: "hi"}, true},
+		{"empty text", Message{ContentType: MessageTypeText}, false},
+		{"text with media", Message{ContentType: MessageTypeText, Content: "hi", MediaID: ptr("m")}, false},
+		{"text with post", Message{ContentType: MessageTypeText, Content: "hi", SharedPostID: ptr("p")}, false},
+		{"text with location", Message{ContentType: MessageTypeText, Content: "hi", Latitude: ptr(1.0)}, false},
+
+		{"image", Message{ContentType: MessageTypeImage, MediaID: ptr("m")}, true},
+		{"image with caption", Message{ContentType: MessageTypeImage, MediaID: ptr("m"), Content: "look"}, true},
+		{"video", Message{ContentType: MessageTypeVideo, MediaID: ptr("m")}, true},
+		{"audio", Message{ContentType: MessageTypeAudio, MediaID: ptr("m")}, true},
+		{"image without media", Message{ContentType: MessageTypeImage}, false},
+		{"audio with post", Message{ContentType: MessageTypeAudio, MediaID: ptr("m"), SharedPostID: ptr("p")}, false},
+		{"video with location", Message{ContentType: MessageTypeVideo, MediaID: ptr("m"), Longitude: ptr(1.0)}, false},
+
+		{"location", Message{ContentType: MessageTypeLocation, Latitude: ptr(52.5), Longitude: ptr(13.4)}, true},
+		{"location at the limits", Message{ContentType: MessageTypeLocation, Latitude: ptr(-90.0), Longitude: ptr(180.0)}, true},
+		{"named location", Message{ContentType: MessageTypeLocation, Latitude: ptr(0.0), Longitude: ptr(0.0), LocationName: ptr("Cafe")}, true},
+		{"location without longitude", Message{ContentType: MessageTypeLocation, Latitude: ptr(52.5)}, false},
+		{"latitude out of range", Message{ContentType: MessageTypeLocation, Latitude: ptr(90.1), Longitude: ptr(0.0)}, false},
+		{"longitude out of range", Message{ContentType: MessageTypeLocation, Latitude: ptr(0.0), Longitude: ptr(-180.1)}, false},
+		{"location name too long", Message{ContentType: MessageTypeLocation, Latitude: ptr(0.0), Longitude: ptr(0.0), LocationName: ptr(strings.Repeat("a", 101))}, false},
+		{"location with media", Message{ContentType: MessageTypeLocation, Latitude: ptr(0.0), Longitude: ptr(0.0), MediaID: ptr("m")}, false},
+
+		{"post share", Message{ContentType: MessageTypePostShare, SharedPostID: ptr("p")}, true},
+		{"post share without post", Message{ContentType: MessageTypePostShare}, false},
+		{"post share with media", Message{ContentType: MessageTypePostShare, SharedPostID: ptr("p"), MediaID: ptr("m")}, false},
+
+		{"system", Message{ContentType: MessageTypeSystem, Content: "changed the timer"}, false},
+		{"unknown type", Message{ContentType: "sticker", Content: "hi"}, false},
+		{"missing type", Message{Content: "hi"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.message.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package types
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// Mute kinds
+const (
+	MuteUser    = "user"
+	MuteKeyword = "keyword"
+	MuteHashtag = "hashtag"
+)
+
+// Mute hides a user's, keyword's or hashtag's content from a user's feed, explore and notifications without blocking
+type Mute struct {
+	MuteID    string     `json:"mute_id" db:"mute_id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	Kind      string     `json:"kind" db:"kind"`
+	Value     string     `json:"value" db:"value"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// Normalize validates a mute and puts its value in the form stored and matched against
+func (m *Mute) Normalize() error {
+	m.Value = strings.TrimSpace(m.Value)
+	switch m.Kind {
+	case MuteUser:
+		id, err := uuid.Parse(m.Value)
+		if err != nil {
+			return errors.New("value must be a user ID")
+		}
+		m.Value = id.String()
+		if m.Value == m.UserID {
+			return errors.New("cannot mute yourself")
+		}
+	case MuteKeyword:
+		m.Value = strings.ToLower(m.Value)
+		if m.Value == "" || len(m.Value) > 100 {
+			return errors.New("keyword must be between 1 and 100 characters")
+		}
+	case MuteHashtag:
+		m.Value = strings.ToLower(strings.TrimPrefix(m.Value, "#"))
+		if !hashtagPattern.MatchString("#" + m.Value) {
+			return errors.New("invalid hashtag")
+		}
+	default:
+		return errors.New("kind must be user, keyword or hashtag")
+	}
+
+	if m.ExpiresAt != nil && !m.ExpiresAt.After(time.Now()) {
+		return errors.New("expires_at must be in the future")
+	}
+	return nil
+}
+
+// Create a mute, replacing the expiry of an existing mute on the same target
+func (m *Mute) Create(ctx context.Context, db *sqlx.DB) error {
+	query := `INSERT INTO mutes (mute_id, user_id, kind, value, expires_at, created_at) VALUES (:mute_id, :user_id, :kind, :value, :expires_at, :created_at)
+			  ON CONFLICT (user_id, kind, value) DO UPDATE SET expires_at = EXCLUDED.expires_at
+			  RETURNING mute_id, created_at`
+	rows, err := db.NamedQueryContext(ctx, query, m)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		return rows.Scan(&m.MuteID, &m.CreatedAt)
+	}
+	return rows.Err()
+}
+
+// Delete one of a user's mutes. Reports false if it didn't exist.
+func DeleteMute(ctx context.Context, db *sqlx.DB, muteID, userID string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM mutes WHERE mute_id = $1 AND user_id = $2`, muteID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// List a user's active mutes
+func ListMutes(ctx context.Context, db *sqlx.DB, userID string) ([]Mute, error) {
+	var mutes []Mute
+	query := `SELECT * FROM mutes WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW()) ORDER BY created_at DESC`
+	err := db.SelectContext(ctx, &mutes, query, userID)
+	return mutes, err
+}
+
+// DeleteExpiredMutes removes mutes whose duration has passed
+func DeleteExpiredMutes(ctx context.Context, db *sqlx.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM mutes WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// activeMutes selects the active mutes of the user given by SQL expression viewer
+func activeMutes(viewer string) string {
+	return `SELECT 1 FROM mutes m WHERE m.user_id = ` + viewer + ` AND (m.expires_at IS NULL OR m.expires_at > NOW())`
+}
+
+// NotMutedPost returns the condition that a post aliased as p isn't muted by the viewer given by SQL expression viewer,
+// whether through its author, the author of the post it reposts, a keyword in its text or one of its hashtags.
+// A plain repost has no text or tags of its own, so its keywords and hashtags are those of the original.
+func NotMutedPost(viewer string) string {
+	source := `(CASE WHEN p.original_post_id IS NOT NULL AND COALESCE(p.content, '') = '' THEN p.original_post_id ELSE p.post_id END)`
+	return `NOT EXISTS (` + activeMutes(viewer) + ` AND (
+		(m.kind = 'user' AND (m.value = p.user_id::text OR m.value = (SELECT mo.user_id::text FROM posts mo WHERE mo.post_id = p.original_post_id)))
+		OR (m.kind = 'keyword' AND EXISTS (SELECT 1 FROM posts mp WHERE mp.post_id = ` + source + `
+			AND strpos(lower(COALESCE(mp.content, '') || ' ' || COALESCE(mp.caption, '')), m.value) > 0))
+		OR (m.kind = 'hashtag' AND EXISTS (SELECT 1 FROM post_tags mpt JOIN tags mt ON mt.tag_id = mpt.tag_id WHERE mpt.post_id = ` + source + ` AND mt.name = m.value))))`
+}
+
+// NotMutedNotification returns the condition that a notification from the user given by SQL expression actor with
+// text given by SQL expression content isn't muted by the recipient given by viewer
+func NotMutedNotification(viewer, actor, content string) string {
+	return `NOT EXISTS (` + activeMutes(viewer) + ` AND (
+		(m.kind = 'user' AND m.value = (` + actor + `)::text)
+		OR (m.kind = 'keyword' AND strpos(lower(` + content + `), m.value) > 0)))`
+}
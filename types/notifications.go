@@ -2,12 +2,16 @@ package types
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"strconv"
 	"time"
 	"github.com/jmoiron/sqlx"
 	"github.com/google/uuid"
 )
 
-// Notification represents a notification for a user
+// Notification represents a notification for a user. Grouped notifications stand for every distinct actor
+// who did the same thing to the same target while the notification was unread; ActorID is the latest of them.
 type Notification struct {
 	NotificationID string    `json:"notification_id" db:"notification_id"`
 	UserID         string    `json:"user_id" db:"user_id"`
@@ -15,6 +19,24 @@ type Notification struct {
 	Content        string    `json:"content" db:"content"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	IsRead         bool      `json:"is_read" db:"is_read"`
+	ActorID        *string   `json:"actor_id,omitempty" db:"actor_id"`
+	TargetID       *string   `json:"target_id,omitempty" db:"target_id"`
+	ActorCount     int       `json:"actor_count" db:"actor_count"`
+	GroupKey       *string   `json:"-" db:"group_key"`
+}
+
+// Notice is a notification to deliver: ActorID did Action to UserID, for example "liked your post". Notices from the
+// service itself, such as those in the reserved moderation category, have no actor. Notices with a GroupKey collapse
+// into the recipient's unread notification with the same key.
+type Notice struct {
+	UserID   string
+	ActorID  string
+	Type     string
+	Category string
+	TargetID string
+	Action   string
+	Text     string // text involved, such as a comment, checked against the recipient's keyword mutes
+	GroupKey string
 }
 
 // Create a new notification
@@ -44,6 +66,130 @@ func (n *Notification) Delete(ctx context.Context, db *sqlx.DB, notificationID u
 	return err
 }
 
+// Delivery is a notice that passed the recipient's preferences, with the channels beyond the app it should also go out on
+type Delivery struct {
+	UserID  string
+	Type    string
+	Content string
+	Push    bool
+	Email   bool
+}
+
+// noticeContent renders a notification's text, such as "alice and 12 others liked your post"
+func noticeContent(actorName string, others int, action string) string {
+	switch {
+	case actorName == "":
+		return action
+	case others == 1:
+		return actorName + " and 1 other " + action
+	case others > 1:
+		return actorName + " and " + strconv.Itoa(others) + " others " + action
+	}
+	return actorName + " " + action
+}
+
+// Notify delivers a notice to a user according to their preferences for its category, unless they have turned
+// notifications off, are in a block with the actor, have muted the actor or a keyword in the notice, or only hear about
+// this category from people they follow. The in-app notification is written here; the returned delivery says which other
+// channels the caller should send it on, and is nil when the notice goes nowhere. Grouped notices from a new actor bump
+// the count of the matching unread notification instead of creating another; repeats from the same actor leave it as it is.
+func Notify(ctx context.Context, db *sqlx.DB, n Notice) (*Delivery, error) {
+	if n.UserID == n.ActorID {
+		return nil, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pref, err := readPreference(ctx, tx, n.UserID, n.Category)
+	if err != nil {
+		return nil, err
+	}
+	if !pref.Enabled() {
+		return nil, nil
+	}
+
+	var actorID *string
+	if n.ActorID != "" {
+		actorID = &n.ActorID
+	}
+
+	// Locking the recipient serializes their notifications so concurrent notices can't start duplicate groups
+	var actorName string
+	query := `SELECT COALESCE(a.username, '') FROM users u LEFT JOIN users a ON a.user_id = $2
+			  WHERE u.user_id = $1 AND u.notifications_enabled AND ($2::uuid IS NULL OR (a.user_id IS NOT NULL
+				AND ` + NotBlocked("u.user_id", "a.user_id") + `
+				AND ` + NotMutedNotification("u.user_id", "a.user_id", "$3") + `
+				AND ($4 = '` + AudienceEveryone + `' OR EXISTS (SELECT 1 FROM followings f WHERE f.follower_id = u.user_id AND f.following_id = a.user_id))))
+			  FOR UPDATE OF u`
+	if err := tx.GetContext(ctx, &actorName, query, n.UserID, actorID, n.Action+" "+n.Text, pref.Audience); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	delivery := &Delivery{UserID: n.UserID, Type: n.Type, Content: noticeContent(actorName, 0, n.Action), Push: pref.Push, Email: pref.Email}
+	if !pref.InApp {
+		return delivery, tx.Commit()
+	}
+
+	var groupKey, targetID *string
+	if n.GroupKey != "" {
+		groupKey = &n.GroupKey
+	}
+	if n.TargetID != "" {
+		targetID = &n.TargetID
+	}
+
+	if groupKey != nil {
+		var existing Notification
+		query = `SELECT * FROM notifications WHERE user_id = $1 AND group_key = $2 AND NOT is_read`
+		err := tx.GetContext(ctx, &existing, query, n.UserID, n.GroupKey)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil {
+			res, err := tx.ExecContext(ctx, `INSERT INTO notification_actors (notification_id, actor_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, existing.NotificationID, n.ActorID)
+			if err != nil {
+				return nil, err
+			}
+			added, err := res.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if added == 0 {
+				// A repeat like or follow from the same actor isn't news; each new message still is
+				if n.Category == CategoryLikes || n.Category == CategoryFollows {
+					return nil, tx.Commit()
+				}
+				return delivery, tx.Commit()
+			}
+			query = `UPDATE notifications SET actor_id = $2, actor_count = actor_count + 1, content = $3, created_at = NOW() WHERE notification_id = $1`
+			if _, err := tx.ExecContext(ctx, query, existing.NotificationID, n.ActorID, noticeContent(actorName, existing.ActorCount, n.Action)); err != nil {
+				return nil, err
+			}
+			return delivery, tx.Commit()
+		}
+	}
+
+	notificationID := uuid.New().String()
+	query = `INSERT INTO notifications (notification_id, user_id, type, content, created_at, is_read, actor_id, target_id, actor_count, group_key)
+			 VALUES ($1, $2, $3, $4, NOW(), FALSE, $5, $6, 1, $7)`
+	if _, err := tx.ExecContext(ctx, query, notificationID, n.UserID, n.Type, delivery.Content, actorID, targetID, groupKey); err != nil {
+		return nil, err
+	}
+	if groupKey != nil && actorID != nil {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO notification_actors (notification_id, actor_id) VALUES ($1, $2)`, notificationID, n.ActorID); err != nil {
+			return nil, err
+		}
+	}
+	return delivery, tx.Commit()
+}
+
 // List notifications for a user
 func ListNotifications(ctx context.Context, db *sqlx.DB, userID string) ([]Notification, error) {
 	var notifications []Notification
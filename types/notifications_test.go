@@ -0,0 +1,24 @@
+package types
+
+import "testing"
+
+func TestNoticeContent(t *testing.T) {
+	tests := []struct {
+		actor  string
+		others int
+		want   string
+	}{
+		{"alice", 0, "alice liked your post"},
+		{"alice", 1, "alice and 1 other liked your post"},
+		{"alice", 2, "alice and 2 others liked your post"},
+		{"alice", 41, "alice and 41 others liked your post"},
+		{"alice", -1, "alice liked your post"},
+		{"", 0, "liked your post"},
+		{"", 3, "liked your post"},
+	}
+	for _, tt := range tests {
+		if got := noticeContent(tt.actor, tt.others, "liked your post"); got != tt.want {
+			t.Errorf("noticeContent(%q, %d) = %q, want %q", tt.actor, tt.others, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package types
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Block policy. When either user has blocked the other, neither can see the other's profile, posts,
+// comments or likes, nor follow, comment on, like, message or mention them. NotBlocked is the SQL
+// form used inside queries and CanInteract the check used before writes; nothing else should read
+// blocked_users directly.
+
+// NotBlocked returns the condition that neither of the users given by SQL expressions a and b has blocked the other
+func NotBlocked(a, b string) string {
+	return `NOT EXISTS (SELECT 1 FROM blocked_users bp WHERE (bp.blocker_id = ` + a + ` AND bp.blocked_user_id = ` + b + `)
+		OR (bp.blocker_id = ` + b + ` AND bp.blocked_user_id = ` + a + `))`
+}
+
+// CanInteract reports whether actorID may view or act on targetID's account and content
+func CanInteract(ctx context.Context, db *sqlx.DB, actorID, targetID string) (bool, error) {
+	if actorID == targetID {
+		return true, nil
+	}
+	var allowed bool
+	err := db.GetContext(ctx, &allowed, `SELECT `+NotBlocked("$1::uuid", "$2::uuid"), actorID, targetID)
+	return allowed, err
+}
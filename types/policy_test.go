@@ -0,0 +1,44 @@
+package types
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCanInteractWithSelfNeedsNoDatabase(t *testing.T) {
+	// A nil database would panic if the self check fell through to a query
+	allowed, err := CanInteract(context.Background(), nil, "user-a", "user-a")
+	if err != nil || !allowed {
+		t.Errorf("CanInteract(self) = %v, %v; want true, nil", allowed, err)
+	}
+}
+
+func TestNotBlockedChecksBothDirections(t *testing.T) {
+	cond := NotBlocked("$1", "u.user_id")
+	for _, want := range []string{
+		"bp.blocker_id = $1 AND bp.blocked_user_id = u.user_id",
+		"bp.blocker_id = u.user_id AND bp.blocked_user_id = $1",
+	} {
+		if !strings.Contains(cond, want) {
+			t.Errorf("NotBlocked is missing %q:\n%s", want, cond)
+		}
+	}
+	if !strings.HasPrefix(cond, "NOT EXISTS") {
+		t.Errorf("NotBlocked should exclude matching blocks:\n%s", cond)
+	}
+}
+
+func TestReadPathsApplyTheBlockPolicy(t *testing.T) {
+	conditions := []struct {
+		name, cond, want string
+	}{
+		{"VisibleTo", VisibleTo("$2"), NotBlocked("p.user_id", "$2")},
+		{"LivePost reposts", LivePost, NotBlocked("o.user_id", "p.user_id")},
+	}
+	for _, c := range conditions {
+		if !strings.Contains(c.cond, c.want) {
+			t.Errorf("%s does not apply the block policy:\n%s", c.name, c.cond)
+		}
+	}
+}
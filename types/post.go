@@ -1,6 +1,33 @@
 package types
 
-import "time"
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// LivePost is the condition a post aliased as p must meet to be visible on any read path.
+// Reposts additionally require their original to be live and no block between the two authors.
+var LivePost = `(p.status = 'published' AND (p.expires_at IS NULL OR p.expires_at > NOW())
+	AND (p.original_post_id IS NULL OR EXISTS (
+		SELECT 1 FROM posts o WHERE o.post_id = p.original_post_id
+		AND o.status = 'published' AND o.visibility = 'public' AND (o.expires_at IS NULL OR o.expires_at > NOW())
+		AND ` + NotBlocked("o.user_id", "p.user_id") + `)))`
+
+// Post statuses
+const (
+	PostPublished = "published"
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+)
+
+// MaxPostLifetime is the longest an ephemeral post may stay up
+var MaxPostLifetime = EnvDuration("POST_MAX_LIFETIME", 7*24*time.Hour)
 
 type Post struct {
     PostID       string    `json:"post_id" db:"post_id"`
@@ -12,9 +39,315 @@ type Post struct {
     Longitude    float64   `json:"longitude" db:"longitude"`
     LocationName string    `json:"location_name" db:"location_name"`
     CreatedAt    time.Time `json:"created_at" db:"created_at"`
+    ExpiresAt    *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+    Status       string    `json:"status" db:"status"`
+    PublishAt    *time.Time `json:"publish_at,omitempty" db:"publish_at"`
+    OriginalPostID *string `json:"original_post_id,omitempty" db:"original_post_id"`
+    Original     *Post     `json:"original,omitempty" db:"-"`
+    Visibility   string    `json:"visibility" db:"visibility"`
+    LikeCount    int       `json:"like_count" db:"like_count"`
+    CommentsDisabled bool  `json:"comments_disabled" db:"comments_disabled"`
+    PinnedCommentID *string `json:"pinned_comment_id,omitempty" db:"pinned_comment_id"`
+    Reactions    map[string]int `json:"reactions,omitempty" db:"-"`
+    ViewerReaction string  `json:"viewer_reaction,omitempty" db:"-"`
+    MentionedIDs []string  `json:"-" db:"-"` // users newly mentioned when the post went live
 }
 
 type PostTag struct {
     PostID string `json:"post_id" db:"post_id"`
     TagID  string `json:"tag_id" db:"tag_id"`
-}
\ No newline at end of file
+}
+
+// Validate post creation
+func (p *Post) Validate() error {
+	validation := validator.New()
+	var PostValidation = map[string]string{
+		"Latitude":     "latitude",
+		"Longitude":    "longitude",
+		"LocationName": "required,max=255",
+		"PhotoURL":     "omitempty,url,max=255",
+		"Status":       "oneof=published draft scheduled",
+		"Visibility":   "oneof=public followers close_friends",
+	}
+
+	validation.RegisterStructValidationMapRules(PostValidation, Post{})
+	if err := validation.Struct(p); err != nil {
+		return err
+	}
+
+	// Ephemeral lifetimes are measured from when the post goes live
+	start := time.Now()
+	switch p.Status {
+	case PostScheduled:
+		if p.PublishAt == nil || !p.PublishAt.After(start) {
+			return errors.New("publish_at must be in the future for scheduled posts")
+		}
+		start = *p.PublishAt
+	case PostPublished:
+		if p.PublishAt != nil {
+			return errors.New("publish_at is only allowed on scheduled posts")
+		}
+	}
+
+	if p.ExpiresAt != nil {
+		if !p.ExpiresAt.After(start) {
+			return errors.New("expires_at must be after the post goes live")
+		}
+		if p.ExpiresAt.After(start.Add(MaxPostLifetime)) {
+			return errors.New("expires_at is too far in the future")
+		}
+	}
+	return nil
+}
+
+// Create a new post. Published posts get their tags, mentions and feed entries in the same transaction.
+func (p *Post) Create(ctx context.Context, db *sqlx.DB) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO posts (post_id, user_id, content, photo_url, caption, latitude, longitude, location_name, created_at, expires_at, status, publish_at, original_post_id, visibility) VALUES (:post_id, :user_id, :content, :photo_url, :caption, :latitude, :longitude, :location_name, :created_at, :expires_at, :status, :publish_at, :original_post_id, :visibility)`
+	if _, err := tx.NamedExecContext(ctx, query, p); err != nil {
+		return err
+	}
+
+	if p.Status == PostPublished {
+		if err := p.fanOut(ctx, tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Publish a draft or scheduled post. Reports false if the post was already published.
+func (p *Post) Publish(ctx context.Context, db *sqlx.DB) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE posts SET status = 'published', publish_at = NULL, created_at = NOW() WHERE post_id = $1 AND status <> 'published' RETURNING *`
+	if err := tx.GetContext(ctx, p, query, p.PostID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if err := p.fanOut(ctx, tx); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// NewRepost builds a repost of original by userID. A non-empty content makes it a quote post.
+func NewRepost(original *Post, userID, content string) *Post {
+	originalID := original.PostID
+	return &Post{
+		PostID:         uuid.New().String(),
+		UserID:         userID,
+		Content:        content,
+		Latitude:       original.Latitude,
+		Longitude:      original.Longitude,
+		LocationName:   original.LocationName,
+		CreatedAt:      time.Now(),
+		Status:         PostPublished,
+		OriginalPostID: &originalID,
+		Visibility:     VisibilityPublic,
+	}
+}
+
+// DeleteRepost removes a user's plain repost of a post. Reports false if there was none.
+func DeleteRepost(ctx context.Context, db *sqlx.DB, originalPostID, userID string) (bool, error) {
+	query := `DELETE FROM posts WHERE original_post_id = $1 AND user_id = $2 AND COALESCE(content, '') = ''`
+	res, err := db.ExecContext(ctx, query, originalPostID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AttachOriginals loads the original post of every repost in posts
+func AttachOriginals(ctx context.Context, db *sqlx.DB, posts ...*Post) error {
+	var ids []string
+	for _, p := range posts {
+		if p.OriginalPostID != nil {
+			ids = append(ids, *p.OriginalPostID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var originals []Post
+	query := `SELECT * FROM posts WHERE post_id = ANY($1)`
+	if err := db.SelectContext(ctx, &originals, query, pq.Array(ids)); err != nil {
+		return err
+	}
+
+	byID := make(map[string]*Post, len(originals))
+	for i := range originals {
+		byID[originals[i].PostID] = &originals[i]
+	}
+	for _, p := range posts {
+		if p.OriginalPostID != nil {
+			p.Original = byID[*p.OriginalPostID]
+		}
+	}
+	return nil
+}
+
+// Target returns the post that engagement on p applies to: the original for plain reposts, otherwise p itself
+func (p *Post) Target() string {
+	if p.OriginalPostID != nil && p.Content == "" {
+		return *p.OriginalPostID
+	}
+	return p.PostID
+}
+
+// SetCommentsDisabled turns comments on a user's post off or on. Reports false if the post doesn't belong to the user.
+func SetCommentsDisabled(ctx context.Context, db *sqlx.DB, postID, userID string, disabled bool) (bool, error) {
+	query := `UPDATE posts SET comments_disabled = $1 WHERE post_id = $2 AND user_id = $3`
+	res, err := db.ExecContext(ctx, query, disabled, postID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Read a post owned by a user regardless of whether it is live
+func (p *Post) ReadOwned(ctx context.Context, db *sqlx.DB, postID, userID string) error {
+	query := `SELECT * FROM posts WHERE post_id = $1 AND user_id = $2`
+	return db.GetContext(ctx, p, query, postID, userID)
+}
+
+// UpdateUnpublished updates a draft or scheduled post. Reports false if it no longer exists or was published.
+func (p *Post) UpdateUnpublished(ctx context.Context, db *sqlx.DB) (bool, error) {
+	query := `UPDATE posts SET content=:content, photo_url=:photo_url, caption=:caption, latitude=:latitude, longitude=:longitude, location_name=:location_name, expires_at=:expires_at, status=:status, publish_at=:publish_at, visibility=:visibility
+			  WHERE post_id=:post_id AND user_id=:user_id AND status <> 'published'`
+	res, err := db.NamedExecContext(ctx, query, p)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteUnpublished deletes a draft or scheduled post owned by a user. Reports false if none matched.
+func DeleteUnpublished(ctx context.Context, db *sqlx.DB, postID, userID string) (bool, error) {
+	query := `DELETE FROM posts WHERE post_id = $1 AND user_id = $2 AND status <> 'published'`
+	res, err := db.ExecContext(ctx, query, postID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// List a user's drafts and scheduled posts, optionally filtered by status
+func ListUnpublished(ctx context.Context, db *sqlx.DB, userID, status string) ([]Post, error) {
+	var posts []Post
+	query := `SELECT * FROM posts WHERE user_id = $1 AND status <> 'published' AND ($2 = '' OR status = $2) ORDER BY publish_at NULLS LAST, created_at DESC`
+	err := db.SelectContext(ctx, &posts, query, userID, status)
+	return posts, err
+}
+
+// List scheduled posts whose publish time has arrived
+func ListDuePosts(ctx context.Context, db *sqlx.DB, limit int) ([]Post, error) {
+	var posts []Post
+	query := `SELECT * FROM posts WHERE status = 'scheduled' AND publish_at <= NOW() ORDER BY publish_at LIMIT $1`
+	err := db.SelectContext(ctx, &posts, query, limit)
+	return posts, err
+}
+
+// Read a live post by ID, regardless of its audience
+func (p *Post) Read(ctx context.Context, db *sqlx.DB, postID string) error {
+	query := `SELECT * FROM posts p WHERE p.post_id = $1 AND ` + LivePost
+	return db.GetContext(ctx, p, query, postID)
+}
+
+// Read a post by ID if the viewer may see it
+func (p *Post) ReadVisible(ctx context.Context, db *sqlx.DB, postID, viewerID string) error {
+	query := `SELECT * FROM posts p WHERE p.post_id = $1 AND ` + VisibleTo("$2")
+	return db.GetContext(ctx, p, query, postID, viewerID)
+}
+
+// List the posts on a user's profile that the viewer may see
+func ListPostsByUser(ctx context.Context, db *sqlx.DB, userID, viewerID string, limit, offset int) ([]Post, error) {
+	var posts []Post
+	query := `SELECT * FROM posts p WHERE p.user_id = $1 AND ` + VisibleTo("$2") + ` ORDER BY p.created_at DESC LIMIT $3 OFFSET $4`
+	err := db.SelectContext(ctx, &posts, query, userID, viewerID, limit, offset)
+	return posts, err
+}
+
+// DeleteExpiredPosts hard-deletes posts that expired more than retention ago. Their likes, comments and views,
+// and any reposts and quotes of them, are removed by the database's cascades. A post's photo_url points at an
+// image the client hosts elsewhere, not at media stored here, so there is no file of ours to remove with it;
+// uploads in the media table are only ever attached to messages and are cleaned up by the orphaned media sweep.
+func DeleteExpiredPosts(ctx context.Context, db *sqlx.DB, retention time.Duration) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM posts WHERE expires_at < NOW() - make_interval(secs => $1)`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Delete a post by ID
+func (p *Post) Delete(ctx context.Context, db *sqlx.DB, postID string) error {
+	query := `DELETE FROM posts WHERE post_id = $1`
+	_, err := db.ExecContext(ctx, query, postID)
+	return err
+}
+
+// PostView records that a user has seen a post
+type PostView struct {
+	UserID   string    `json:"user_id" db:"user_id"`
+	PostID   string    `json:"post_id" db:"post_id"`
+	ViewedAt time.Time `json:"viewed_at" db:"viewed_at"`
+}
+
+// Create a post view, ignoring repeat views of the same post
+func (v *PostView) Create(ctx context.Context, db *sqlx.DB) error {
+	query := `INSERT INTO post_views (user_id, post_id, viewed_at) VALUES (:user_id, :post_id, :viewed_at) ON CONFLICT (user_id, post_id) DO NOTHING`
+	_, err := db.NamedExecContext(ctx, query, v)
+	return err
+}
+
+// fanOut runs the side effects of a post going live: tags, mentions and follower feeds
+func (p *Post) fanOut(ctx context.Context, tx *sqlx.Tx) error {
+	text := p.Content + " " + p.Caption
+
+	for _, name := range ExtractHashtags(text) {
+		var tagID string
+		query := `INSERT INTO tags (tag_id, name) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING tag_id`
+		if err := tx.GetContext(ctx, &tagID, query, uuid.New().String(), name); err != nil {
+			return err
+		}
+		query = `INSERT INTO post_tags (post_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, p.PostID, tagID); err != nil {
+			return err
+		}
+	}
+
+	p.MentionedIDs = nil
+	if mentions := ExtractMentions(text); len(mentions) > 0 {
+		query := `INSERT INTO post_mentions (post_id, user_id)
+				  SELECT $1, u.user_id FROM users u WHERE u.username = ANY($2) AND u.user_id <> $3 AND ` + NotBlocked("u.user_id", "$3") + `
+				  ON CONFLICT DO NOTHING
+				  RETURNING user_id`
+		if err := tx.SelectContext(ctx, &p.MentionedIDs, query, p.PostID, pq.Array(mentions), p.UserID); err != nil {
+			return err
+		}
+	}
+
+	query := `INSERT INTO feed_items (user_id, post_id, created_at)
+			  SELECT follower_id, $1, NOW() FROM followings WHERE following_id = $2
+			  ON CONFLICT DO NOTHING`
+	_, err := tx.ExecContext(ctx, query, p.PostID, p.UserID)
+	return err
+}
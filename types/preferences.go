@@ -0,0 +1,126 @@
+package types
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Notification categories users set preferences for. Moderation is reserved for notices about action taken on a
+// user's account or content; users can already set it, but nothing emits moderation notices yet.
+const (
+	CategoryLikes      = "likes"
+	CategoryComments   = "comments"
+	CategoryFollows    = "follows"
+	CategoryMentions   = "mentions"
+	CategoryMessages   = "messages"
+	CategoryModeration = "moderation"
+)
+
+// Notification audiences: whose actions a user hears about
+const (
+	AudienceEveryone  = "everyone"
+	AudienceFollowing = "following"
+)
+
+// NotificationCategories lists every category in the order settings are shown
+var NotificationCategories = []string{CategoryLikes, CategoryComments, CategoryFollows, CategoryMentions, CategoryMessages, CategoryModeration}
+
+// NotificationPreference is how a user wants to hear about one category of notification, on each channel
+type NotificationPreference struct {
+	UserID   string `json:"-" db:"user_id"`
+	Category string `json:"-" db:"category"`
+	InApp    bool   `json:"in_app" db:"in_app"`
+	Push     bool   `json:"push" db:"push"`
+	Email    bool   `json:"email" db:"email"`
+	Audience string `json:"audience" db:"audience"`
+}
+
+// NotificationSettings are a user's notification preferences by category
+type NotificationSettings map[string]*NotificationPreference
+
+// defaultPreference is the preference of a user who hasn't changed a category. Messages already show in the inbox,
+// so they only push; moderation notices matter enough to email.
+func defaultPreference(userID, category string) NotificationPreference {
+	p := NotificationPreference{UserID: userID, Category: category, InApp: true, Push: true, Audience: AudienceEveryone}
+	switch category {
+	case CategoryMessages:
+		p.InApp = false
+	case CategoryModeration:
+		p.Email = true
+	}
+	return p
+}
+
+// Validate checks a preference's audience. Moderation notices come from the service rather than another user,
+// so they can't be limited to people the user follows.
+func (p *NotificationPreference) Validate() error {
+	switch {
+	case p.Audience != AudienceEveryone && p.Audience != AudienceFollowing:
+		return errors.New("audience must be everyone or following")
+	case p.Category == CategoryModeration && p.Audience != AudienceEveryone:
+		return errors.New("moderation notices can't be limited to people you follow")
+	}
+	return nil
+}
+
+// Enabled reports whether the preference delivers on any channel
+func (p *NotificationPreference) Enabled() bool {
+	return p.InApp || p.Push || p.Email
+}
+
+// ReadNotificationSettings loads a user's preferences for every category, filling in defaults for those never changed
+func ReadNotificationSettings(ctx context.Context, db *sqlx.DB, userID string) (NotificationSettings, error) {
+	settings := make(NotificationSettings, len(NotificationCategories))
+	for _, category := range NotificationCategories {
+		p := defaultPreference(userID, category)
+		settings[category] = &p
+	}
+
+	var saved []NotificationPreference
+	if err := db.SelectContext(ctx, &saved, `SELECT * FROM notification_preferences WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for i := range saved {
+		if _, ok := settings[saved[i].Category]; ok {
+			settings[saved[i].Category] = &saved[i]
+		}
+	}
+	return settings, nil
+}
+
+// readPreference loads a user's preference for one category within tx
+func readPreference(ctx context.Context, tx *sqlx.Tx, userID, category string) (NotificationPreference, error) {
+	p := defaultPreference(userID, category)
+	query := `SELECT * FROM notification_preferences WHERE user_id = $1 AND category = $2`
+	rows, err := tx.QueryxContext(ctx, query, userID, category)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.StructScan(&p)
+	}
+	return p, err
+}
+
+// Save every preference in a user's settings
+func (s NotificationSettings) Save(ctx context.Context, db *sqlx.DB) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO notification_preferences (user_id, category, in_app, push, email, audience)
+			  VALUES (:user_id, :category, :in_app, :push, :email, :audience)
+			  ON CONFLICT (user_id, category) DO UPDATE SET in_app = EXCLUDED.in_app, push = EXCLUDED.push,
+				email = EXCLUDED.email, audience = EXCLUDED.audience`
+	for _, p := range s {
+		if _, err := tx.NamedExecContext(ctx, query, p); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PresenceTimeout is how long after its last heartbeat a user is still shown online, covering servers that
+// went away without closing their connections
+var PresenceTimeout = EnvDuration("PRESENCE_TIMEOUT", 90*time.Second)
+
+// Presence is whether a user has a live realtime connection and when they were last seen
+type Presence struct {
+	UserID     string     `json:"user_id" db:"user_id"`
+	Online     bool       `json:"online" db:"online"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty" db:"last_seen_at"`
+}
+
+// Connect records a new realtime connection for a user
+func Connect(ctx context.Context, db *sqlx.DB, userID string) error {
+	query := `INSERT INTO user_presence (user_id, connections, last_seen_at) VALUES ($1, 1, NOW())
+			  ON CONFLICT (user_id) DO UPDATE SET connections = user_presence.connections + 1, last_seen_at = NOW()`
+	_, err := db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Disconnect records a closed realtime connection, reporting whether it was the user's last
+func Disconnect(ctx context.Context, db *sqlx.DB, userID string) (bool, error) {
+	var remaining int
+	query := `UPDATE user_presence SET connections = GREATEST(connections - 1, 0), last_seen_at = NOW() WHERE user_id = $1 RETURNING connections`
+	err := db.GetContext(ctx, &remaining, query, userID)
+	return remaining == 0, err
+}
+
+// Heartbeat marks a connected user as seen now
+func Heartbeat(ctx context.Context, db *sqlx.DB, userID string) error {
+	_, err := db.ExecContext(ctx, `UPDATE user_presence SET last_seen_at = NOW() WHERE user_id = $1`, userID)
+	return err
+}
+
+// ReadPresence loads a user's presence. Users who have never connected are offline with no last seen time.
+func ReadPresence(ctx context.Context, db *sqlx.DB, userID string) (Presence, error) {
+	presence := Presence{UserID: userID}
+	query := `SELECT user_id, connections > 0 AND last_seen_at > NOW() - make_interval(secs => $2) AS online, last_seen_at
+			  FROM user_presence WHERE user_id = $1`
+	rows, err := db.QueryxContext(ctx, query, userID, PresenceTimeout.Seconds())
+	if err != nil {
+		return presence, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.StructScan(&presence)
+	}
+	return presence, err
+}
+
+// ListContactIDs returns the users who currently share a conversation with userID and aren't in a block with them,
+// the audience for their presence
+func ListContactIDs(ctx context.Context, db *sqlx.DB, userID string) ([]string, error) {
+	var ids []string
+	query := `SELECT DISTINCT o.user_id FROM conversation_participants me
+			  JOIN conversation_participants o ON o.conversation_id = me.conversation_id AND o.user_id <> me.user_id
+			  WHERE me.user_id = $1 AND me.left_at IS NULL AND o.left_at IS NULL AND ` + NotBlocked("o.user_id", "$1")
+	err := db.SelectContext(ctx, &ids, query, userID)
+	return ids, err
+}
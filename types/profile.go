@@ -0,0 +1,49 @@
+package types
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// userSummaryColumns selects a UserSummary from users aliased as u
+const userSummaryColumns = `u.user_id, u.username, COALESCE(u.first_name, '') AS first_name, COALESCE(u.last_name, '') AS last_name,
+	COALESCE(u.profile_picture_url, '') AS profile_picture_url, COALESCE(u.verified, FALSE) AS verified, COALESCE(u.creator, FALSE) AS creator`
+
+// UserSummary is the public subset of a user shown in lists
+type UserSummary struct {
+	UserID            string `json:"user_id" db:"user_id"`
+	Username          string `json:"username" db:"username"`
+	FirstName         string `json:"first_name,omitempty" db:"first_name"`
+	LastName          string `json:"last_name,omitempty" db:"last_name"`
+	ProfilePictureURL string `json:"profile_picture_url,omitempty" db:"profile_picture_url"`
+	Verified          bool   `json:"verified" db:"verified"`
+	Creator           bool   `json:"creator" db:"creator"`
+}
+
+// Profile is a user's public profile as seen by a viewer
+type Profile struct {
+	UserSummary
+	UserBio        string `json:"user_bio,omitempty" db:"user_bio"`
+	FollowerCount  int    `json:"follower_count" db:"follower_count"`
+	FollowingCount int    `json:"following_count" db:"following_count"`
+	FollowsYou     bool   `json:"follows_you" db:"follows_you"`
+	Following      bool   `json:"following" db:"following"`
+	Mutual         bool   `json:"mutual" db:"-"`
+}
+
+// ReadProfile loads userID's profile as seen by viewerID
+func ReadProfile(ctx context.Context, db *sqlx.DB, userID, viewerID string) (Profile, error) {
+	var profile Profile
+	query := `SELECT ` + userSummaryColumns + `, COALESCE(u.user_bio, '') AS user_bio,
+				(SELECT COUNT(*) FROM followings WHERE following_id = u.user_id) AS follower_count,
+				(SELECT COUNT(*) FROM followings WHERE follower_id = u.user_id) AS following_count,
+				EXISTS (SELECT 1 FROM followings WHERE follower_id = u.user_id AND following_id = $2) AS follows_you,
+				EXISTS (SELECT 1 FROM followings WHERE follower_id = $2 AND following_id = u.user_id) AS following
+			  FROM users u WHERE u.user_id = $1`
+	if err := db.GetContext(ctx, &profile, query, userID, viewerID); err != nil {
+		return profile, err
+	}
+	profile.Mutual = profile.FollowsYou && profile.Following
+	return profile, nil
+}
@@ -0,0 +1,164 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Receipt statuses
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// MessageReceipt is when a message reached and was read by one of its recipients
+type MessageReceipt struct {
+	MessageID   string     `json:"message_id" db:"message_id"`
+	UserID      string     `json:"user_id" db:"user_id"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+	ReadAt      *time.Time `json:"read_at,omitempty" db:"read_at"`
+}
+
+// ReceiptUpdate is a receipt that just changed, with what its sender needs to be told about it
+type ReceiptUpdate struct {
+	MessageID      string    `json:"message_id" db:"message_id"`
+	ConversationID string    `json:"conversation_id" db:"conversation_id"`
+	SenderID       string    `json:"-" db:"sender_id"`
+	At             time.Time `json:"at" db:"at"`
+}
+
+// PrivacySettings are a user's choices about what others learn of their activity
+type PrivacySettings struct {
+	UserID       string `json:"-" db:"user_id"`
+	ReadReceipts bool   `json:"read_receipts" db:"read_receipts"`
+}
+
+// ReadPrivacySettings loads a user's privacy settings, which default to sharing everything
+func ReadPrivacySettings(ctx context.Context, db *sqlx.DB, userID string) (PrivacySettings, error) {
+	settings := PrivacySettings{UserID: userID, ReadReceipts: true}
+	query := `SELECT user_id, read_receipts FROM privacy_settings WHERE user_id = $1`
+	rows, err := db.QueryxContext(ctx, query, userID)
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		err = rows.StructScan(&settings)
+	}
+	return settings, err
+}
+
+// Save a user's privacy settings
+func (p *PrivacySettings) Save(ctx context.Context, db *sqlx.DB) error {
+	query := `INSERT INTO privacy_settings (user_id, read_receipts) VALUES (:user_id, :read_receipts)
+			  ON CONFLICT (user_id) DO UPDATE SET read_receipts = EXCLUDED.read_receipts`
+	_, err := db.NamedExecContext(ctx, query, p)
+	return err
+}
+
+// createReceipts adds an undelivered receipt for every current participant of a message's conversation except its sender
+// and anyone on either side of a block with them
+func createReceipts(ctx context.Context, tx *sqlx.Tx, m *Message) error {
+	query := `INSERT INTO message_receipts (message_id, user_id)
+			  SELECT $1, cp.user_id FROM conversation_participants cp
+			  WHERE cp.conversation_id = $2 AND cp.user_id <> $3 AND cp.left_at IS NULL AND ` + NotBlocked("cp.user_id", "$3")
+	_, err := tx.ExecContext(ctx, query, m.MessageID, m.ConversationID, m.SenderID)
+	return err
+}
+
+// readHidden returns the condition that the conversation aliased c is a message request that the user in the
+// parameter user hasn't accepted; requesters don't learn whether their request was read
+func readHidden(c, user string) string {
+	return `(` + c + `.request_state <> 'accepted' AND ` + c + `.requested_by IS NOT NULL AND ` + c + `.requested_by <> ` + user + `)`
+}
+
+// MarkDelivered records that messages reached one of userID's devices, returning the receipts that changed
+func MarkDelivered(ctx context.Context, db *sqlx.DB, userID string, messageIDs []string) ([]ReceiptUpdate, error) {
+	var updates []ReceiptUpdate
+	query := `UPDATE message_receipts r SET delivered_at = NOW()
+			  FROM messages m
+			  WHERE m.message_id = r.message_id AND r.user_id = $1 AND r.message_id = ANY($2) AND r.delivered_at IS NULL
+			  RETURNING r.message_id, m.conversation_id, m.sender_id, r.delivered_at AS at`
+	err := db.SelectContext(ctx, &updates, query, userID, pq.Array(messageIDs))
+	return updates, err
+}
+
+// MarkConversationDelivered records that every message in a conversation reached userID
+func MarkConversationDelivered(ctx context.Context, db *sqlx.DB, userID, conversationID string) ([]ReceiptUpdate, error) {
+	var updates []ReceiptUpdate
+	query := `UPDATE message_receipts r SET delivered_at = NOW()
+			  FROM messages m
+			  WHERE m.message_id = r.message_id AND r.user_id = $1 AND m.conversation_id = $2 AND r.delivered_at IS NULL
+			  RETURNING r.message_id, m.conversation_id, m.sender_id, r.delivered_at AS at`
+	err := db.SelectContext(ctx, &updates, query, userID, conversationID)
+	return updates, err
+}
+
+// MarkRead records that userID has read a conversation up to and including messageID, returning the receipts that changed.
+// It returns sql.ErrNoRows when messageID isn't in the conversation.
+func MarkRead(ctx context.Context, db *sqlx.DB, userID, conversationID, messageID string) ([]ReceiptUpdate, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var upTo time.Time
+	query := `SELECT m.timestamp FROM messages m
+			  JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $3
+			  WHERE m.message_id = $1 AND m.conversation_id = $2 AND ` + memberCanSee("cp", "m")
+	if err := tx.GetContext(ctx, &upTo, query, messageID, conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	var updates []ReceiptUpdate
+	query = `UPDATE message_receipts r SET read_at = NOW(), delivered_at = COALESCE(r.delivered_at, NOW())
+			  FROM messages m
+			  WHERE m.message_id = r.message_id AND r.user_id = $1 AND m.conversation_id = $2 AND r.read_at IS NULL
+				AND (m.timestamp, m.message_id) <= ($3, $4)
+			  RETURNING r.message_id, m.conversation_id, m.sender_id, r.read_at AS at`
+	if err := tx.SelectContext(ctx, &updates, query, userID, conversationID, upTo, messageID); err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE conversation_participants SET last_read_at = GREATEST(last_read_at, $3) WHERE conversation_id = $1 AND user_id = $2`
+	if _, err := tx.ExecContext(ctx, query, conversationID, userID, upTo); err != nil {
+		return nil, err
+	}
+
+	// is_read is what senders see on their messages, so it only flips when the reader shares read receipts
+	// and has accepted the conversation's message request
+	if len(updates) > 0 {
+		ids := make([]string, len(updates))
+		for i, u := range updates {
+			ids[i] = u.MessageID
+		}
+		query = `UPDATE messages SET is_read = TRUE WHERE message_id = ANY($1)
+				 AND NOT EXISTS (SELECT 1 FROM privacy_settings ps WHERE ps.user_id = $2 AND NOT ps.read_receipts)
+				 AND NOT EXISTS (SELECT 1 FROM conversations c WHERE c.conversation_id = $3 AND ` + readHidden("c", "$2") + `)`
+		if _, err := tx.ExecContext(ctx, query, pq.Array(ids), userID, conversationID); err != nil {
+			return nil, err
+		}
+	}
+
+	return updates, tx.Commit()
+}
+
+// ListReceipts returns a message's receipts, hiding read times of recipients who don't share read receipts
+// or haven't accepted the conversation's message request
+func ListReceipts(ctx context.Context, db *sqlx.DB, messageID string) ([]MessageReceipt, error) {
+	var receipts []MessageReceipt
+	query := `SELECT r.message_id, r.user_id, r.delivered_at,
+				CASE WHEN COALESCE(ps.read_receipts, TRUE) AND NOT ` + readHidden("c", "r.user_id") + ` THEN r.read_at END AS read_at
+			  FROM message_receipts r
+			  JOIN messages m ON m.message_id = r.message_id
+			  JOIN conversations c ON c.conversation_id = m.conversation_id
+			  LEFT JOIN privacy_settings ps ON ps.user_id = r.user_id
+			  WHERE r.message_id = $1
+			  ORDER BY r.user_id`
+	err := db.SelectContext(ctx, &receipts, query, messageID)
+	return receipts, err
+}
@@ -0,0 +1,84 @@
+package types
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Message request states of a direct conversation. A first message to someone who doesn't follow the sender
+// opens a request, which the recipient sees in a separate folder until they accept or decline it.
+const (
+	RequestAccepted = "accepted"
+	RequestPending  = "pending"
+	RequestDeclined = "declined"
+)
+
+// ErrRequestNotAccepted is returned when a sender tries to add to a message request that hasn't been accepted
+var ErrRequestNotAccepted = errors.New("message request has not been accepted")
+
+// admitMessage applies the message request rules to a message about to be sent in a direct conversation, within tx.
+// A requester may send one message until the request is accepted; the recipient replying, or following the
+// requester, accepts it.
+func admitMessage(ctx context.Context, tx *sqlx.Tx, m *Message) error {
+	var conv Conversation
+	if err := tx.GetContext(ctx, &conv, `SELECT * FROM conversations WHERE conversation_id = $1 FOR UPDATE`, m.ConversationID); err != nil {
+		return err
+	}
+	if conv.Kind != ConversationDirect || m.ReceiverID == nil {
+		return nil
+	}
+
+	var state struct {
+		Follows    bool `db:"follows"`
+		HasMessage bool `db:"has_message"`
+		Sent       bool `db:"sent"`
+	}
+	query := `SELECT EXISTS (SELECT 1 FROM followings WHERE follower_id = $2 AND following_id = $3) AS follows,
+				EXISTS (SELECT 1 FROM messages WHERE conversation_id = $1) AS has_message,
+				EXISTS (SELECT 1 FROM messages WHERE conversation_id = $1 AND sender_id = $3) AS sent`
+	if err := tx.GetContext(ctx, &state, query, conv.ConversationID, *m.ReceiverID, m.SenderID); err != nil {
+		return err
+	}
+
+	requester := conv.RequestedBy != nil && *conv.RequestedBy == m.SenderID
+	switch {
+	case conv.RequestState == RequestAccepted && !state.HasMessage && !state.Follows:
+		// First contact with someone who doesn't follow the sender
+		return setRequestState(ctx, tx, conv.ConversationID, RequestPending, &m.SenderID)
+	case conv.RequestState == RequestAccepted:
+		return nil
+	case !requester || state.Follows:
+		return setRequestState(ctx, tx, conv.ConversationID, RequestAccepted, conv.RequestedBy)
+	case conv.RequestState == RequestPending && !state.Sent:
+		return nil
+	default:
+		return ErrRequestNotAccepted
+	}
+}
+
+// setRequestState changes a conversation's message request state
+func setRequestState(ctx context.Context, tx *sqlx.Tx, conversationID, state string, requestedBy *string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE conversations SET request_state = $2, requested_by = $3 WHERE conversation_id = $1`, conversationID, state, requestedBy)
+	return err
+}
+
+// ListRequests returns a page of the pending message requests sent to a user, most recent first
+func ListRequests(ctx context.Context, db *sqlx.DB, userID string, cursor Cursor, limit int) (InboxPage, error) {
+	return listConversations(ctx, db, userID, `(c.request_state = 'pending' AND c.requested_by <> $1)`, cursor, limit)
+}
+
+// RespondToRequest accepts or declines a message request sent to userID. It reports false if there was no such request.
+// A declined request can still be accepted later.
+func RespondToRequest(ctx context.Context, db *sqlx.DB, conversationID, userID, state string) (bool, error) {
+	query := `UPDATE conversations SET request_state = $3
+			  WHERE conversation_id = $1 AND kind = 'direct' AND request_state IN ('pending', 'declined') AND requested_by <> $2
+				AND EXISTS (SELECT 1 FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2)`
+	res, err := db.ExecContext(ctx, query, conversationID, userID, state)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
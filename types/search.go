@@ -0,0 +1,78 @@
+package types
+
+import (
+	"context"
+	"html"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// messageDocument is the text search document of the message aliased m. It must match the expression of the
+// messages_search_idx index for searches to use it.
+func messageDocument(m string) string {
+	return `to_tsvector('simple', ` + m + `.content)`
+}
+
+// Highlight markers ts_headline wraps matches in before the snippet is escaped; control characters can't clash with message text
+const (
+	highlightStart = "\x02"
+	highlightStop  = "\x03"
+)
+
+// SearchResult is a message matching a search with a snippet of its content. Matches in the snippet are wrapped in
+// <mark> tags and everything else is HTML escaped.
+type SearchResult struct {
+	Message
+	Snippet string `json:"snippet" db:"snippet"`
+}
+
+// SearchPage is one page of message search results
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// SearchMessages returns a page of the messages userID can see that match query, newest first, optionally limited to one
+// conversation. Unsent messages, messages the user deleted for themselves and direct conversations with a user in a block
+// with them are never searched.
+func SearchMessages(ctx context.Context, db *sqlx.DB, userID, query string, conversationID *string, cursor Cursor, limit int) (SearchPage, error) {
+	var results []SearchResult
+	sqlQuery := `SELECT m.*, ts_headline('simple', m.content, q.query,
+					'StartSel=` + highlightStart + `, StopSel=` + highlightStop + `, MaxWords=30, MinWords=10, MaxFragments=2') AS snippet
+				 FROM messages m
+				 JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+				 JOIN conversations c ON c.conversation_id = m.conversation_id
+				 CROSS JOIN websearch_to_tsquery('simple', $2) AS q(query)
+				 WHERE ` + messageDocument("m") + ` @@ q.query
+				   AND m.unsent_at IS NULL AND m.content_type <> 'system'
+				   AND ($3::uuid IS NULL OR m.conversation_id = $3)
+				   AND (m.timestamp, m.message_id) < ($4, $5)
+				   AND ` + memberCanSee("cp", "m") + `
+				   AND ` + notHidden("m", "$1") + `
+				   AND (c.kind <> 'direct' OR NOT EXISTS (SELECT 1 FROM conversation_participants o
+					 WHERE o.conversation_id = c.conversation_id AND o.user_id <> $1 AND NOT ` + NotBlocked("o.user_id", "$1") + `))
+				 ORDER BY m.timestamp DESC, m.message_id DESC LIMIT $6`
+	if err := db.SelectContext(ctx, &results, sqlQuery, userID, query, conversationID, cursor.Time, cursor.ID, limit+1); err != nil {
+		return SearchPage{}, err
+	}
+
+	page := SearchPage{Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+		last := page.Results[limit-1]
+		page.NextCursor = Cursor{Time: last.Timestamp, ID: last.MessageID}.Encode()
+	}
+	if page.Results == nil {
+		page.Results = []SearchResult{}
+	}
+	for i := range page.Results {
+		page.Results[i].Snippet = escapeSnippet(page.Results[i].Snippet)
+	}
+	return page, nil
+}
+
+// escapeSnippet escapes a ts_headline snippet for HTML and turns its highlight markers into <mark> tags
+func escapeSnippet(snippet string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(snippet))
+}
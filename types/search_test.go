@@ -0,0 +1,25 @@
+package types
+
+import "testing"
+
+func TestEscapeSnippet(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain", "hello world", "hello world"},
+		{"highlight", "say " + highlightStart + "hello" + highlightStop + " world", "say <mark>hello</mark> world"},
+		{"several highlights", highlightStart + "a" + highlightStop + " b " + highlightStart + "c" + highlightStop, "<mark>a</mark> b <mark>c</mark>"},
+		{"html in text", `<script>alert("x")</script>`, "&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;"},
+		{"html in highlight", highlightStart + "<b>bold</b>" + highlightStop, "<mark>&lt;b&gt;bold&lt;/b&gt;</mark>"},
+		{"fake mark tags", "<mark>not ours</mark>", "&lt;mark&gt;not ours&lt;/mark&gt;"},
+		{"entities and quotes", `Tom & Jerry's "show"`, "Tom &amp; Jerry&#39;s &#34;show&#34;"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeSnippet(tt.in); got != tt.want {
+				t.Errorf("escapeSnippet(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
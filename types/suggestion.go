@@ -0,0 +1,199 @@
+package types
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SuggestionWeights controls how each signal contributes to a follow suggestion's score
+type SuggestionWeights struct {
+	Mutuals          float64 // weight of followings who already follow the candidate
+	Proximity        float64 // weight of closeness to the user
+	SharedTags       float64 // weight of hashtags both users have posted
+	Creator          float64 // bonus for creator accounts
+	ProximityScaleKm float64 // distance at which the proximity signal halves
+	NearbyRadiusKm   float64 // users within this radius are considered even without other signals
+	CandidatePool    int     // candidates scored per user
+	PerUser          int     // suggestions stored per user
+}
+
+// SuggestionWeightsFromEnv loads suggestion weights from the environment, using sensible defaults. The proximity
+// scale divides distances, so values that aren't positive fall back to the default.
+func SuggestionWeightsFromEnv() SuggestionWeights {
+	return SuggestionWeights{
+		Mutuals:          EnvFloat("SUGGEST_WEIGHT_MUTUALS", 3.0),
+		Proximity:        EnvFloat("SUGGEST_WEIGHT_PROXIMITY", 1.5),
+		SharedTags:       EnvFloat("SUGGEST_WEIGHT_SHARED_TAGS", 1.0),
+		Creator:          EnvFloat("SUGGEST_WEIGHT_CREATOR", 0.5),
+		ProximityScaleKm: EnvPositiveFloat("SUGGEST_PROXIMITY_SCALE_KM", 25),
+		NearbyRadiusKm:   EnvFloat("SUGGEST_NEARBY_RADIUS_KM", 50),
+		CandidatePool:    EnvInt("SUGGEST_CANDIDATE_POOL", 500),
+		PerUser:          EnvInt("SUGGEST_PER_USER", 50),
+	}
+}
+
+// SuggestionCandidate is a potential follow along with the signals used to rank it
+type SuggestionCandidate struct {
+	CandidateID string  `db:"candidate_id"`
+	Latitude    float64 `db:"latitude"`
+	Longitude   float64 `db:"longitude"`
+	Creator     bool    `db:"creator"`
+	Mutuals     int     `db:"mutuals"`
+	SharedTags  int     `db:"shared_tags"`
+}
+
+// Suggestion is a precomputed follow suggestion
+type Suggestion struct {
+	UserID      string    `json:"-" db:"user_id"`
+	CandidateID string    `json:"-" db:"candidate_id"`
+	Score       float64   `json:"score" db:"score"`
+	Mutuals     int       `json:"mutuals" db:"mutuals"`
+	SharedTags  int       `json:"shared_tags" db:"shared_tags"`
+	DistanceKm  *float64  `json:"distance_km,omitempty" db:"distance_km"`
+	ComputedAt  time.Time `json:"computed_at" db:"computed_at"`
+}
+
+// SuggestedUser is a suggested candidate's profile summary with the reasons they were suggested
+type SuggestedUser struct {
+	UserSummary
+	Score      float64   `json:"score" db:"score"`
+	Mutuals    int       `json:"mutuals" db:"mutuals"`
+	SharedTags int       `json:"shared_tags" db:"shared_tags"`
+	DistanceKm *float64  `json:"distance_km,omitempty" db:"distance_km"`
+	ComputedAt time.Time `json:"computed_at" db:"computed_at"`
+}
+
+// hasLocation reports whether coordinates were actually set; unset locations are stored as 0,0
+func hasLocation(lat, lon float64) bool {
+	return lat != 0 || lon != 0
+}
+
+// ListSuggestionCandidates gathers candidates for a user from friends of friends, shared hashtags and nearby users,
+// excluding the user, existing follows, blocks in either direction and dismissed suggestions
+func ListSuggestionCandidates(ctx context.Context, db *sqlx.DB, user *User, w SuggestionWeights) ([]SuggestionCandidate, error) {
+	// A bounding box keeps the nearby scan on the coordinate index; exact distances are computed when scoring
+	latDelta := w.NearbyRadiusKm / 111.0
+	lonDelta := latDelta / math.Max(math.Cos(user.Latitude*math.Pi/180), 0.01)
+	nearby := hasLocation(user.Latitude, user.Longitude)
+
+	var candidates []SuggestionCandidate
+	query := `WITH mine AS (SELECT following_id FROM followings WHERE follower_id = $1),
+			  fof AS (
+				SELECT f.following_id AS candidate_id, COUNT(*) AS mutuals
+				FROM followings f WHERE f.follower_id IN (SELECT following_id FROM mine)
+				GROUP BY f.following_id),
+			  my_tags AS (
+				SELECT DISTINCT pt.tag_id FROM post_tags pt JOIN posts p ON p.post_id = pt.post_id WHERE p.user_id = $1),
+			  tag_overlap AS (
+				SELECT p.user_id AS candidate_id, COUNT(DISTINCT pt.tag_id) AS shared_tags
+				FROM post_tags pt JOIN posts p ON p.post_id = pt.post_id
+				WHERE pt.tag_id IN (SELECT tag_id FROM my_tags)
+				GROUP BY p.user_id),
+			  near AS (
+				SELECT user_id AS candidate_id FROM users
+				WHERE $2 AND latitude BETWEEN $3::numeric - $5 AND $3::numeric + $5 AND longitude BETWEEN $4::numeric - $6 AND $4::numeric + $6),
+			  candidates AS (
+				SELECT candidate_id FROM fof UNION SELECT candidate_id FROM tag_overlap UNION SELECT candidate_id FROM near)
+			  SELECT u.user_id AS candidate_id, COALESCE(u.latitude, 0) AS latitude, COALESCE(u.longitude, 0) AS longitude,
+				COALESCE(u.creator, FALSE) AS creator, COALESCE(fof.mutuals, 0) AS mutuals, COALESCE(t.shared_tags, 0) AS shared_tags
+			  FROM candidates c
+			  JOIN users u ON u.user_id = c.candidate_id
+			  LEFT JOIN fof ON fof.candidate_id = c.candidate_id
+			  LEFT JOIN tag_overlap t ON t.candidate_id = c.candidate_id
+			  WHERE u.user_id <> $1
+				AND u.user_id NOT IN (SELECT following_id FROM mine)
+				AND ` + NotBlocked("$1", "u.user_id") + `
+				AND NOT EXISTS (SELECT 1 FROM suggestion_dismissals d WHERE d.user_id = $1 AND d.candidate_id = u.user_id)
+			  ORDER BY mutuals DESC, shared_tags DESC
+			  LIMIT $7`
+	err := db.SelectContext(ctx, &candidates, query, user.UserID, nearby, user.Latitude, user.Longitude, latDelta, lonDelta, w.CandidatePool)
+	return candidates, err
+}
+
+// RankSuggestions scores candidates for a user and returns the top PerUser suggestions
+func RankSuggestions(user *User, candidates []SuggestionCandidate, w SuggestionWeights, now time.Time) []Suggestion {
+	suggestions := make([]Suggestion, 0, len(candidates))
+	for _, c := range candidates {
+		s := Suggestion{
+			UserID:      user.UserID,
+			CandidateID: c.CandidateID,
+			Mutuals:     c.Mutuals,
+			SharedTags:  c.SharedTags,
+			ComputedAt:  now,
+		}
+
+		s.Score = w.Mutuals*math.Log1p(float64(c.Mutuals)) + w.SharedTags*math.Log1p(float64(c.SharedTags))
+		if hasLocation(user.Latitude, user.Longitude) && hasLocation(c.Latitude, c.Longitude) {
+			distance := DistanceKm(user.Latitude, user.Longitude, c.Latitude, c.Longitude)
+			s.DistanceKm = &distance
+			s.Score += w.Proximity / (1 + distance/w.ProximityScaleKm)
+		}
+		if c.Creator {
+			s.Score += w.Creator
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].Score > suggestions[j].Score })
+	if len(suggestions) > w.PerUser {
+		suggestions = suggestions[:w.PerUser]
+	}
+	return suggestions
+}
+
+// ReplaceSuggestions swaps a user's stored suggestions for a freshly computed set
+func ReplaceSuggestions(ctx context.Context, db *sqlx.DB, userID string, suggestions []Suggestion) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM follow_suggestions WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	query := `INSERT INTO follow_suggestions (user_id, candidate_id, score, mutuals, shared_tags, distance_km, computed_at)
+			  VALUES (:user_id, :candidate_id, :score, :mutuals, :shared_tags, :distance_km, :computed_at)`
+	for _, s := range suggestions {
+		if _, err := tx.NamedExecContext(ctx, query, s); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListSuggestions returns a user's stored suggestions, best first. Follows, blocks and dismissals made since
+// the suggestions were computed are filtered out here.
+func ListSuggestions(ctx context.Context, db *sqlx.DB, userID string, limit int) ([]SuggestedUser, error) {
+	var suggestions []SuggestedUser
+	query := `SELECT ` + userSummaryColumns + `, s.score, s.mutuals, s.shared_tags, s.distance_km, s.computed_at
+			  FROM follow_suggestions s JOIN users u ON u.user_id = s.candidate_id
+			  WHERE s.user_id = $1
+				AND NOT EXISTS (SELECT 1 FROM followings f WHERE f.follower_id = $1 AND f.following_id = s.candidate_id)
+				AND ` + NotBlocked("$1", "s.candidate_id") + `
+				AND NOT EXISTS (SELECT 1 FROM suggestion_dismissals d WHERE d.user_id = $1 AND d.candidate_id = s.candidate_id)
+			  ORDER BY s.score DESC LIMIT $2`
+	err := db.SelectContext(ctx, &suggestions, query, userID, limit)
+	return suggestions, err
+}
+
+// DismissSuggestion stops a candidate from being suggested to a user again
+func DismissSuggestion(ctx context.Context, db *sqlx.DB, userID, candidateID string) error {
+	query := `INSERT INTO suggestion_dismissals (user_id, candidate_id, dismissed_at) VALUES ($1, $2, NOW()) ON CONFLICT DO NOTHING`
+	_, err := db.ExecContext(ctx, query, userID, candidateID)
+	return err
+}
+
+// ListUsersAfter returns up to limit users with IDs after afterID, for batch jobs that walk every user.
+// Start from uuid.Nil to walk from the beginning.
+func ListUsersAfter(ctx context.Context, db *sqlx.DB, afterID string, limit int) ([]User, error) {
+	var users []User
+	query := `SELECT user_id, username, COALESCE(latitude, 0) AS latitude, COALESCE(longitude, 0) AS longitude
+			  FROM users WHERE user_id > $1 ORDER BY user_id LIMIT $2`
+	err := db.SelectContext(ctx, &users, query, afterID, limit)
+	return users, err
+}
@@ -0,0 +1,65 @@
+package types
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]{1,50})`)
+	// A mention starts the text or follows a non-word character, so email addresses aren't mentions
+	mentionPattern = regexp.MustCompile(`(?:^|[^\w])@([A-Za-z0-9_.]{1,15})`)
+)
+
+// Tag represents a hashtag
+type Tag struct {
+	TagID string `json:"tag_id" db:"tag_id"`
+	Name  string `json:"name" db:"name"`
+}
+
+// PostMention represents a user mentioned in a post
+type PostMention struct {
+	PostID string `json:"post_id" db:"post_id"`
+	UserID string `json:"user_id" db:"user_id"`
+}
+
+// ExtractHashtags returns the unique, lowercased hashtags in text
+func ExtractHashtags(text string) []string {
+	return uniqueMatches(hashtagPattern, text, true)
+}
+
+// ExtractMentions returns the unique usernames mentioned in text
+func ExtractMentions(text string) []string {
+	return uniqueMatches(mentionPattern, text, false)
+}
+
+func uniqueMatches(pattern *regexp.Regexp, text string, lower bool) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, m := range pattern.FindAllStringSubmatch(text, -1) {
+		v := m[1]
+		if lower {
+			v = strings.ToLower(v)
+		}
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// List posts with a hashtag that the viewer may see
+func ListPostsByTag(ctx context.Context, db *sqlx.DB, name, viewerID string, limit, offset int) ([]Post, error) {
+	var posts []Post
+	query := `SELECT p.* FROM posts p
+			  JOIN post_tags pt ON pt.post_id = p.post_id
+			  JOIN tags t ON t.tag_id = pt.tag_id
+			  WHERE t.name = $1 AND ` + VisibleTo("$2") + `
+			  ORDER BY p.created_at DESC LIMIT $3 OFFSET $4`
+	err := db.SelectContext(ctx, &posts, query, strings.ToLower(name), viewerID, limit, offset)
+	return posts, err
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractMentions(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"@alice hi", []string{"alice"}},
+		{"hi @alice and @bob.smith", []string{"alice", "bob.smith"}},
+		{"(@alice), @alice again", []string{"alice"}},
+		{"@alice,@bob", []string{"alice", "bob"}},
+		{"mail bob@example.com", nil},
+		{"a@b and x_@y", nil},
+		{"no mentions", nil},
+	}
+	for _, c := range cases {
+		if got := ExtractMentions(c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ExtractMentions(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+}
+
+func TestExtractHashtags(t *testing.T) {
+	cases := []struct {
+		text string
+		want []string
+	}{
+		{"#Go and #go", []string{"go"}},
+		{"#café #日本", []string{"café", "日本"}},
+		{"none", nil},
+	}
+	for _, c := range cases {
+		if got := ExtractHashtags(c.text); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ExtractHashtags(%q) = %v, want %v", c.text, got, c.want)
+		}
+	}
+}
@@ -111,6 +111,12 @@ func (u *User) Read(ctx context.Context, db *sqlx.DB, userID uuid.UUID) error {
 	return db.GetContext(ctx, u, query, userID)
 }
 
+// Read a user by username
+func (u *User) ReadByUsername(ctx context.Context, db *sqlx.DB, username string) error {
+	query := `SELECT * FROM users WHERE username = $1`
+	return db.GetContext(ctx, u, query, username)
+}
+
 // Update a user
 func (u *User) Update(ctx context.Context, db *sqlx.DB) error {
 	query := `UPDATE users SET username=:username, user_password=:user_password, email=:email, email_verified=:email_verified, first_name=:first_name, last_name=:last_name, user_bio=:user_bio, birthday=:birthday, updated_at=:updated_at, verified=:verified, profile_picture_url=:profile_picture_url, notifications_enabled=:notifications_enabled, flagged=:flagged, rank=:rank, creator=:creator, salt=:salt, latitude=:latitude, longitude=:longitude, session_token=:session_token
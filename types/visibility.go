@@ -0,0 +1,65 @@
+package types
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Post visibility levels
+const (
+	VisibilityPublic       = "public"
+	VisibilityFollowers    = "followers"
+	VisibilityCloseFriends = "close_friends"
+)
+
+// VisibleTo returns the condition a post aliased as p must meet for the viewer bound
+// to the given placeholder (e.g. "$1") to see it. It includes LivePost and the block policy, and is the only
+// place audience rules are decided; every query that returns posts, or comments and
+// likes on posts, must go through it.
+func VisibleTo(viewer string) string {
+	audience := `(p.user_id = {v}
+		OR p.visibility = 'public'
+		OR (p.visibility = 'followers' AND EXISTS (SELECT 1 FROM followings fv WHERE fv.follower_id = {v} AND fv.following_id = p.user_id))
+		OR (p.visibility = 'close_friends' AND EXISTS (SELECT 1 FROM close_friends cf WHERE cf.user_id = p.user_id AND cf.friend_id = {v})))`
+	return `(` + LivePost + ` AND ` + NotBlocked("p.user_id", viewer) + ` AND ` + strings.ReplaceAll(audience, "{v}", viewer) + `)`
+}
+
+// CanViewPost reports whether viewerID may see postID
+func CanViewPost(ctx context.Context, db *sqlx.DB, viewerID, postID string) (bool, error) {
+	var visible bool
+	query := `SELECT EXISTS (SELECT 1 FROM posts p WHERE p.post_id = $2 AND ` + VisibleTo("$1") + `)`
+	err := db.GetContext(ctx, &visible, query, viewerID, postID)
+	return visible, err
+}
+
+// CloseFriend represents a user on another user's close friends list
+type CloseFriend struct {
+	UserID    string    `json:"user_id" db:"user_id"`
+	FriendID  string    `json:"friend_id" db:"friend_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Create a close friend entry, ignoring duplicates
+func (c *CloseFriend) Create(ctx context.Context, db *sqlx.DB) error {
+	query := `INSERT INTO close_friends (user_id, friend_id, created_at) VALUES (:user_id, :friend_id, :created_at) ON CONFLICT (user_id, friend_id) DO NOTHING`
+	_, err := db.NamedExecContext(ctx, query, c)
+	return err
+}
+
+// Delete a close friend entry
+func (c *CloseFriend) Delete(ctx context.Context, db *sqlx.DB) error {
+	query := `DELETE FROM close_friends WHERE user_id = $1 AND friend_id = $2`
+	_, err := db.ExecContext(ctx, query, c.UserID, c.FriendID)
+	return err
+}
+
+// List a user's close friends
+func ListCloseFriends(ctx context.Context, db *sqlx.DB, userID string) ([]CloseFriend, error) {
+	var friends []CloseFriend
+	query := `SELECT * FROM close_friends WHERE user_id = $1 ORDER BY created_at DESC`
+	err := db.SelectContext(ctx, &friends, query, userID)
+	return friends, err
+}